@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sync/atomic"
+)
+
+// par2 recovery volumes, e.g. "file.vol07+08.par2"
+var par2VolumeRegexp = regexp.MustCompile(`(?i)\.vol\d+\+(\d+)\.par2$`)
+
+var errNzbDead = fmt.Errorf("the NZB file is beyond saving")
+
+// state of the early abort of the current run
+var earlyAbort struct {
+	segments           uint64       // number of segments to check in this run
+	recoveryBytes      int64        // size of the par2 recovery volumes of the NZB file
+	unrecoverableBytes atomic.Int64 // missing data
+	lostRecoveryBytes  atomic.Int64 // missing par2 recovery volumes
+	aborted            atomic.Bool
+	cancel             context.CancelFunc
+}
+
+// setupEarlyAbort resets the early abort for a new run, cancel stops the run
+func setupEarlyAbort(segments int, cancel context.CancelFunc) {
+	earlyAbort.segments = uint64(segments)
+	earlyAbort.recoveryBytes = 0
+	for _, file := range nzbfile.Files {
+		if isPar2Volume(file.Filename) {
+			for _, segment := range file.Segments {
+				earlyAbort.recoveryBytes += int64(segment.Bytes)
+			}
+		}
+	}
+	earlyAbort.unrecoverableBytes.Store(0)
+	earlyAbort.lostRecoveryBytes.Store(0)
+	earlyAbort.aborted.Store(false)
+	earlyAbort.cancel = cancel
+}
+
+func isPar2Volume(fileName string) bool {
+	return par2VolumeRegexp.MatchString(fileName)
+}
+
+// unrecoverableSegment aborts the run once more than the configured share of the segments is missing on all providers
+// and the missing data exceeds the size of the par2 recovery volumes, as they could not compensate the missing segments anymore
+func unrecoverableSegment(segmentBytes int, fileName string) {
+	if args.AbortThreshold <= 0 {
+		return
+	}
+	// missing recovery volumes only reduce the recovery capacity
+	if isPar2Volume(fileName) {
+		earlyAbort.lostRecoveryBytes.Add(int64(segmentBytes))
+	} else {
+		earlyAbort.unrecoverableBytes.Add(int64(segmentBytes))
+	}
+	unrecoverable := runProgress.unrecoverable.Load()
+	share := float64(unrecoverable) / float64(earlyAbort.segments) * 100
+	if share > args.AbortThreshold && earlyAbort.unrecoverableBytes.Load() > recoveryCapacity() && earlyAbort.aborted.CompareAndSwap(false, true) {
+		log.Printf("aborting the run: %v segments (%.2f%%) are missing on all providers, which exceeds the threshold of %v%% and the par2 recovery volumes", unrecoverable, share, args.AbortThreshold)
+		earlyAbort.cancel()
+	}
+}
+
+// recoveryCapacity returns the size of the par2 recovery volumes which are still available
+func recoveryCapacity() int64 {
+	return earlyAbort.recoveryBytes - earlyAbort.lostRecoveryBytes.Load()
+}
+
+// abortResult returns the evidence why the run was aborted
+func abortResult() string {
+	unrecoverable := runProgress.unrecoverable.Load()
+	return fmt.Sprintf("Run aborted after checking %v of %v segments: %v segments (%.2f%% of all segments) are missing on all providers, "+
+		"%v bytes are missing while the available par2 recovery volumes are only %v bytes",
+		runProgress.segmentsChecked.Load(), earlyAbort.segments, unrecoverable, float64(unrecoverable)/float64(earlyAbort.segments)*100,
+		earlyAbort.unrecoverableBytes.Load(), recoveryCapacity())
+}
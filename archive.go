@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Tensai75/cmpb"
+	"github.com/Tensai75/nntp"
+	"github.com/Tensai75/nzbparser"
+)
+
+// The archive is a zip file with one deflate compressed entry per article,
+// named after the hash of the message ID (as in the spool directory) and with the message ID as comment.
+
+var (
+	archive     *zip.ReadCloser // archive opened for the re-seed of the current run
+	archiveLock sync.Mutex
+	reseeded    atomic.Uint64 // number of articles re-seeded from the archive in the current run
+)
+
+// archiveFileName returns the default path of the archive of the NZB file
+func archiveFileName(path string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".gz"), ".nzb") + ".archive.zip"
+}
+
+// openArchive opens the archive for reading, it stays open until the end of the run
+func openArchive(path string) (*zip.ReadCloser, error) {
+	archiveLock.Lock()
+	defer archiveLock.Unlock()
+	if archive != nil {
+		return archive, nil
+	}
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the archive '%s': %v", path, err)
+	}
+	archive = reader
+	return archive, nil
+}
+
+// closeArchive closes the archive at the end of the run,
+// so the next run (e.g. of the schedule or serve command) reads the archive as it is by then
+func closeArchive() {
+	archiveLock.Lock()
+	defer archiveLock.Unlock()
+	if archive != nil {
+		archive.Close()
+		archive = nil
+	}
+}
+
+// readArchivedArticle returns the article with the message ID from the archive
+func readArchivedArticle(reader *zip.ReadCloser, messageID string) (*nntp.Article, error) {
+	file, err := reader.Open(spoolFileName(messageID))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	message, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseRawArticle(message)
+}
+
+// reseedArticle re-posts an article missing on all providers from the archive with its original message ID.
+// It returns false if the archive doesn't contain the article.
+func reseedArticle(segment nzbparser.NzbSegment, missingOn []*Provider) bool {
+	if args.Archive == "" || args.Export != "" {
+		return false
+	}
+	reader, err := openArchive(args.Archive)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	article, err := readArchivedArticle(reader, segment.Id)
+	if err != nil {
+		log.Printf("article <%s> is not in the archive: %v", segment.Id, err)
+		return false
+	}
+	log.Printf("re-seeding article <%s> from the archive", segment.Id)
+	if seedArticle(article, segment.Id, "archive", missingOn) {
+		reseeded.Add(1)
+	}
+	return true
+}
+
+// seedArticle posts an article missing on all providers to one provider of each backbone.
+// It returns true if the article was posted, in a dry run the uploads are only added to the plan with the source of the article.
+func seedArticle(article *nntp.Article, segmentID string, source string, missingOn []*Provider) bool {
+	if !addUpload() {
+		return false
+	}
+	planSetSource(segmentID, source)
+	defer func() {
+		uploadBar.Increment()
+		runProgress.uploadsDone.Add(1)
+	}()
+	posted := false
+	for _, targets := range uploadTargets(missingOn) {
+		if _, err := reuploadArticle(targets, article, segmentID); err != nil {
+			log.Print(err)
+		} else {
+			posted = true
+		}
+	}
+	if !posted {
+		planSetError(segmentID, fmt.Errorf("unable to post article <%s> to any provider", segmentID))
+	}
+	return posted && !args.DryRun
+}
+
+func runArchive() {
+	setupLogging(archiveArgs.NZBFile, archiveArgs.Debug)
+
+	// the settings of the archive subcommand apply to the downloads
+	args.Provider = archiveArgs.Provider
+	args.Topology = archiveArgs.Topology
+	args.NoValidation = archiveArgs.NoValidation
+
+	log.Print("preparing...")
+	preparationStartTime = time.Now()
+	if nzbfile, err = loadNzbFile(archiveArgs.NZBFile); err != nil {
+		exit(fmt.Errorf("unable to load NZB file '%s': %v'", archiveArgs.NZBFile, err))
+	}
+
+	// the articles already archived by an earlier run are kept
+	var existing *zip.ReadCloser
+	archived := make(map[string]bool)
+	if _, err := os.Stat(archiveArgs.Archive); err == nil {
+		if existing, err = zip.OpenReader(archiveArgs.Archive); err != nil {
+			exit(fmt.Errorf("unable to open the archive '%s': %v", archiveArgs.Archive, err))
+		}
+		for _, file := range existing.File {
+			archived[file.Name] = true
+		}
+	}
+	var pending []segmentChanItem
+	total := 0
+	for _, file := range nzbfile.Files {
+		for _, segment := range file.Segments {
+			total++
+			if name := spoolFileName(segment.Id); !archived[name] {
+				archived[name] = true
+				pending = append(pending, segmentChanItem{segment, file.Filename, file.Subject})
+			}
+		}
+	}
+	fmt.Printf("Archive '%s': %v of %v articles already archived\n", archiveArgs.Archive, total-len(pending), total)
+	log.Printf("archive '%s': %v of %v articles already archived", archiveArgs.Archive, total-len(pending), total)
+	if len(pending) == 0 {
+		return
+	}
+
+	setupProviders(args.Provider)
+	log.Printf("preparation took %v", time.Since(preparationStartTime))
+
+	// the new archive is written to a temporary file, which replaces the archive when all articles are written
+	tmpFile, err := os.CreateTemp(filepath.Dir(archiveArgs.Archive), filepath.Base(archiveArgs.Archive)+".*.tmp")
+	if err != nil {
+		exit(fmt.Errorf("unable to create the archive: %v", err))
+	}
+	writer := zip.NewWriter(tmpFile)
+	if existing != nil {
+		for _, file := range existing.File {
+			if err := writer.Copy(file); err != nil {
+				exit(fmt.Errorf("unable to copy the archive: %v", err))
+			}
+		}
+	}
+
+	providers := make([]*Provider, 0, len(providerList))
+	for n := range providerList {
+		providers = append(providers, &providerList[n])
+	}
+	var added, failed atomic.Uint64
+	var writerLock sync.Mutex
+	var writeErr error
+	progressBars = cmpb.NewWithParam(&progressBarsParam)
+	bar := progressBars.NewBar("Archiving articles", len(pending))
+	bar.SetPreBar(cmpb.CalcSteps)
+	bar.SetPostBar(cmpb.CalcTime)
+	progressBars.Start()
+
+	itemChan := make(chan segmentChanItem)
+	var wg sync.WaitGroup
+	for i := uint32(0); i < maxConns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range itemChan {
+				if article, err := loadArticle(providers, item.segment, item.fileSubject); err != nil {
+					log.Print(err)
+					failed.Add(1)
+				} else if body, err := io.ReadAll(article.Body); err != nil {
+					log.Print(fmt.Errorf("unable to read article <%s>: %v", item.segment.Id, err))
+					failed.Add(1)
+				} else {
+					writerLock.Lock()
+					if err := writeArchivedArticle(writer, item.segment.Id, rawArticle(article.Header, body)); err != nil && writeErr == nil {
+						writeErr = err
+					}
+					writerLock.Unlock()
+					added.Add(1)
+				}
+				bar.Increment()
+			}
+		}()
+	}
+	for _, item := range pending {
+		itemChan <- item
+	}
+	close(itemChan)
+	wg.Wait()
+	bar.SetMessage("done")
+	progressBars.Wait()
+	closePools()
+
+	if writeErr != nil {
+		exit(fmt.Errorf("unable to write the archive: %v", writeErr))
+	}
+	if err := writer.Close(); err != nil {
+		exit(fmt.Errorf("unable to write the archive: %v", err))
+	}
+	if err := tmpFile.Close(); err != nil {
+		exit(fmt.Errorf("unable to write the archive: %v", err))
+	}
+	if existing != nil {
+		existing.Close()
+	}
+	// the archive is only replaced if articles were added
+	if added.Load() > 0 {
+		if err := os.Rename(tmpFile.Name(), archiveArgs.Archive); err != nil {
+			exit(fmt.Errorf("unable to write the archive: %v", err))
+		}
+	} else {
+		os.Remove(tmpFile.Name())
+	}
+	var size int64
+	if info, err := os.Stat(archiveArgs.Archive); err == nil {
+		size = info.Size()
+	}
+	result := fmt.Sprintf("%v articles archived | %v not available on any provider | archive '%s' contains %v of %v articles (%v bytes)", added.Load(), failed.Load(), archiveArgs.Archive, total-len(pending)+int(added.Load()), total, size)
+	fmt.Println(result)
+	log.Print(result)
+	if failed.Load() > 0 {
+		exit(fmt.Errorf("%v articles could not be archived, run the command again to retry them", failed.Load()))
+	}
+}
+
+func writeArchivedArticle(writer *zip.Writer, messageID string, message []byte) error {
+	w, err := writer.CreateHeader(&zip.FileHeader{
+		Name:     spoolFileName(messageID),
+		Comment:  messageID,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(message)
+	return err
+}
@@ -6,17 +6,59 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	parser "github.com/alexflint/go-arg"
 )
 
 // arguments structure
 type Args struct {
-	NZBFile   string `arg:"positional" help:"path to the NZB file to be checked"`
-	CheckOnly bool   `arg:"-c, --check" help:"only check availability - don't re-upload"`
-	Provider  string `arg:"-p, --provider" help:"path to the provider JSON config file (Default: './provider.json')"`
-	Debug     bool   `arg:"-d, --debug" help:"logs additional output to log file"`
-	Csv       bool   `arg:"--csv" help:"writes statistic about available segements to a csv file"`
+	NZBFile        string  `arg:"positional" help:"path to the NZB file to be checked"`
+	CheckOnly      bool    `arg:"-c, --check" help:"only check availability - don't re-upload"`
+	Provider       string  `arg:"-p, --provider" help:"path to the provider JSON config file (Default: './provider.json')"`
+	Debug          bool    `arg:"-d, --debug" help:"logs additional output to log file"`
+	Csv            bool    `arg:"--csv" help:"writes statistic about available segements to a csv file"`
+	History        string  `arg:"--history" help:"path to the history database file (Default: './history.jsonl')"`
+	NoHistory      bool    `arg:"--no-history" help:"don't write the results of this run to the history database"`
+	Topology       string  `arg:"--topology" help:"path to the provider topology file written by 'providers topology' (Default: './topology.json')"`
+	Config         string  `arg:"--config" help:"path to the config file with providers and settings (JSON, YAML or TOML)"`
+	Sample         string  `arg:"--sample" help:"only check a sample of the segments of each file, either a number (e.g. '50') or a percentage (e.g. '10%'), implies --check"`
+	DryRun         bool    `arg:"--dry-run" help:"perform the full refresh, but don't upload anything and write the plan of the uploads to a file"`
+	Plan           string  `arg:"--plan" help:"path of the plan file written by --dry-run (Default: './NZBFILENAME.plan.json')"`
+	NoValidation   bool    `arg:"--no-validation" help:"re-upload the articles even if they don't match the segments of the NZB file"`
+	NewMessageIDs  bool    `arg:"--new-message-ids" help:"re-post the missing articles under new message IDs and write a new NZB file"`
+	NzbOut         string  `arg:"--nzb-out" help:"path of the new NZB file written with --new-message-ids (Default: './NZBFILENAME.refreshed.nzb')"`
+	Export         string  `arg:"--export" help:"download the articles needed for the refresh to this spool directory instead of re-uploading them"`
+	Archive        string  `arg:"--archive" help:"re-seed the articles missing on all providers from this archive written by the archive command"`
+	SourceDir      string  `arg:"--source-dir" help:"regenerate the articles missing on all providers from the original files in this directory"`
+	Par2           bool    `arg:"--par2" help:"reconstruct the articles missing on all providers with the par2 files of the NZB file"`
+	AbortThreshold float64 `arg:"--abort-threshold" help:"abort the run if more than this percentage of the segments is missing on all providers and the par2 files cannot compensate (Default: 0 = never abort)"`
+	NotifyArgs
+}
+
+// notification arguments structure (shared by the check, schedule and serve commands)
+type NotifyArgs struct {
+	Webhook       []string `arg:"--webhook,separate" help:"webhook URL to send notifications to (can be given multiple times)"`
+	WebhookFormat string   `arg:"--webhook-format" help:"payload format of the webhooks: auto, json, discord, slack or gotify (Default: 'auto')"`
+	NotifyScript  string   `arg:"--notify-script" help:"path to a script to execute for notifications"`
+}
+
+// history subcommand arguments structure
+type HistoryArgs struct {
+	NZBFile string `arg:"positional" help:"path to the NZB file to show the history for"`
+	History string `arg:"--history" help:"path to the history database file (Default: './history.jsonl')"`
+	Csv     bool   `arg:"--csv" help:"writes the history to a csv file"`
+	Config  string `arg:"--config" help:"path to the config file with providers and settings (JSON, YAML or TOML)"`
+}
+
+// version information
+func (HistoryArgs) Version() string {
+	return fmt.Sprintf("%v %v", appName, appVersion)
+}
+
+// additional description
+func (HistoryArgs) Description() string {
+	return "Shows how the availability of an NZB file decayed over time on each provider\n"
 }
 
 // version information
@@ -29,12 +71,167 @@ func (Args) Epilogue() string {
 	return "For more information visit github.com/Tensai75/nzbrefresh\n"
 }
 
+// schedule subcommand arguments structure
+type ScheduleArgs struct {
+	Schedule       string  `arg:"positional" help:"path to the schedule JSON config file (Default: './schedule.json')"`
+	Provider       string  `arg:"-p, --provider" help:"path to the provider JSON config file (Default: './provider.json')"`
+	Debug          bool    `arg:"-d, --debug" help:"logs additional output to log file"`
+	Csv            bool    `arg:"--csv" help:"writes statistic about available segements to a csv file for each NZB file"`
+	History        string  `arg:"--history" help:"path to the history database file (Default: './history.jsonl')"`
+	NoHistory      bool    `arg:"--no-history" help:"don't write the results of the runs to the history database"`
+	Metrics        string  `arg:"--metrics" help:"address to expose the Prometheus metrics on (e.g. ':9090')"`
+	Topology       string  `arg:"--topology" help:"path to the provider topology file written by 'providers topology' (Default: './topology.json')"`
+	Config         string  `arg:"--config" help:"path to the config file with providers and settings (JSON, YAML or TOML)"`
+	AbortThreshold float64 `arg:"--abort-threshold" help:"abort a run if more than this percentage of the segments is missing on all providers and the par2 files cannot compensate (Default: 0 = never abort)"`
+	NotifyArgs
+}
+
+// version information
+func (ScheduleArgs) Version() string {
+	return fmt.Sprintf("%v %v", appName, appVersion)
+}
+
+// additional description
+func (ScheduleArgs) Description() string {
+	return "Periodically checks and refreshes the NZB files configured in the schedule\n"
+}
+
+// serve subcommand arguments structure
+type ServeArgs struct {
+	Listen         string  `arg:"-l, --listen" help:"address the API server listens on (Default: ':8080')"`
+	Provider       string  `arg:"-p, --provider" help:"path to the provider JSON config file (Default: './provider.json')"`
+	Debug          bool    `arg:"-d, --debug" help:"logs additional output to log file"`
+	Token          string  `arg:"--token" help:"API requests must provide this token as bearer token (required unless listening on a loopback address)"`
+	UploadDir      string  `arg:"--upload-dir" help:"directory to store uploaded NZB files (Default: system temp directory)"`
+	History        string  `arg:"--history" help:"path to the history database file (Default: './history.jsonl')"`
+	NoHistory      bool    `arg:"--no-history" help:"don't write the results of the jobs to the history database"`
+	Topology       string  `arg:"--topology" help:"path to the provider topology file written by 'providers topology' (Default: './topology.json')"`
+	Config         string  `arg:"--config" help:"path to the config file with providers and settings (JSON, YAML or TOML)"`
+	AbortThreshold float64 `arg:"--abort-threshold" help:"abort a job if more than this percentage of the segments is missing on all providers and the par2 files cannot compensate (Default: 0 = never abort)"`
+	NotifyArgs
+}
+
+// version information
+func (ServeArgs) Version() string {
+	return fmt.Sprintf("%v %v", appName, appVersion)
+}
+
+// additional description
+func (ServeArgs) Description() string {
+	return "Runs an HTTP API server to submit and monitor jobs\n"
+}
+
+// providers subcommand arguments structure
+type ProvidersArgs struct {
+	Action   string `arg:"positional" help:"action to perform: test, topology or headers"`
+	Provider string `arg:"-p, --provider" help:"path to the provider JSON config file (Default: './provider.json')"`
+	Debug    bool   `arg:"-d, --debug" help:"logs additional output to log file"`
+	Stat     int    `arg:"--stat" help:"number of STAT commands for the throughput test (Default: 100)"`
+	MaxConns uint32 `arg:"--max-conns" help:"upper limit of connections opened to probe the max connections (Default: twice the configured MaxConns)"`
+	NZBFile  string `arg:"--nzb" help:"NZB file with the message IDs to use for the throughput test (Default: random message IDs), the topology or the headers preview (required)"`
+	Sample   int    `arg:"--sample" help:"number of articles to fetch the headers of for the topology (Default: 20)"`
+	Topology string `arg:"--topology" help:"path to write the provider topology file to (Default: './topology.json')"`
+	Config   string `arg:"--config" help:"path to the config file with providers and settings (JSON, YAML or TOML)"`
+}
+
+// version information
+func (ProvidersArgs) Version() string {
+	return fmt.Sprintf("%v %v", appName, appVersion)
+}
+
+// additional description
+func (ProvidersArgs) Description() string {
+	return "Tests the connection, capabilities and performance of the providers\n"
+}
+
+// post-spool subcommand arguments structure
+type SpoolArgs struct {
+	Spool    string `arg:"positional" help:"path to the spool directory written by --export"`
+	Provider string `arg:"-p, --provider" help:"path to the provider JSON config file (Default: './provider.json')"`
+	Debug    bool   `arg:"-d, --debug" help:"logs additional output to log file"`
+	IHave    bool   `arg:"--ihave" help:"offer the articles with IHAVE instead of POST to the providers supporting it"`
+	Topology string `arg:"--topology" help:"path to the provider topology file written by 'providers topology' (Default: './topology.json')"`
+	Config   string `arg:"--config" help:"path to the config file with providers and settings (JSON, YAML or TOML)"`
+}
+
+// version information
+func (SpoolArgs) Version() string {
+	return fmt.Sprintf("%v %v", appName, appVersion)
+}
+
+// additional description
+func (SpoolArgs) Description() string {
+	return "Uploads the articles of a spool directory written by --export to the providers missing them\n"
+}
+
+// archive subcommand arguments structure
+type ArchiveArgs struct {
+	NZBFile      string `arg:"positional" help:"path to the NZB file to archive the articles of"`
+	Archive      string `arg:"--archive" help:"path to the archive file (Default: './NZBFILENAME.archive.zip')"`
+	Provider     string `arg:"-p, --provider" help:"path to the provider JSON config file (Default: './provider.json')"`
+	Debug        bool   `arg:"-d, --debug" help:"logs additional output to log file"`
+	NoValidation bool   `arg:"--no-validation" help:"archive the articles even if they don't match the segments of the NZB file"`
+	Topology     string `arg:"--topology" help:"path to the provider topology file written by 'providers topology' (Default: './topology.json')"`
+	Config       string `arg:"--config" help:"path to the config file with providers and settings (JSON, YAML or TOML)"`
+}
+
+// version information
+func (ArchiveArgs) Version() string {
+	return fmt.Sprintf("%v %v", appName, appVersion)
+}
+
+// additional description
+func (ArchiveArgs) Description() string {
+	return "Downloads all articles of the NZB file into a local archive, to re-seed them with --archive once they have expired\n"
+}
+
 // global arguments variable
 var args struct {
 	Args
 }
 
-// parser variable
+// global history subcommand arguments variable
+var historyArgs struct {
+	HistoryArgs
+}
+
+// global schedule subcommand arguments variable
+var scheduleArgs struct {
+	ScheduleArgs
+}
+
+// global serve subcommand arguments variable
+var serveArgs struct {
+	ServeArgs
+}
+
+// global providers subcommand arguments variable
+var providersArgs struct {
+	ProvidersArgs
+}
+
+// global archive subcommand arguments variable
+var archiveArgs struct {
+	ArchiveArgs
+}
+
+// global post-spool subcommand arguments variable
+var spoolArgs struct {
+	SpoolArgs
+}
+
+// subcommand given as first argument (empty for the default segment check)
+var command string
+
+// available subcommands and their arguments
+var commands = map[string]interface{}{
+	"archive":    &archiveArgs,
+	"history":    &historyArgs,
+	"post-spool": &spoolArgs,
+	"providers":  &providersArgs,
+	"schedule":   &scheduleArgs,
+	"serve":      &serveArgs,
+}
 
 func parseArguments() {
 	var argParser *parser.Parser
@@ -43,9 +240,26 @@ func parseArguments() {
 		IgnoreEnv: true,
 	}
 
+	dest := interface{}(&args)
+	cmdArgs := os.Args[1:]
+	isCommand := len(cmdArgs) > 0 && commands[cmdArgs[0]] != nil
+	// when called as script by SABnzbd or NZBGet, the arguments are set by the downloader
+	if !isCommand && isPostProcessScript() {
+		command = "postprocess"
+		return
+	}
+	if len(cmdArgs) > 0 {
+		if cmdDest, ok := commands[cmdArgs[0]]; ok {
+			command = cmdArgs[0]
+			parserConfig.Program = fmt.Sprintf("%s %s", filepath.Base(os.Args[0]), command)
+			dest = cmdDest
+			cmdArgs = cmdArgs[1:]
+		}
+	}
+
 	// parse flags
-	argParser, _ = parser.NewParser(parserConfig, &args)
-	if err := parser.Parse(&args); err != nil {
+	argParser, _ = parser.NewParser(parserConfig, dest)
+	if err := argParser.Parse(cmdArgs); err != nil {
 		if err.Error() == "help requested by user" {
 			writeHelp(argParser)
 			os.Exit(0)
@@ -57,19 +271,167 @@ func parseArguments() {
 		log.Fatal(err)
 	}
 
+	// arguments not given on the command line are taken from the environment and the config file
+	if err := applySettings(dest, cmdArgs); err != nil {
+		exit(err)
+	}
+
 	checkArguments(argParser)
 
 }
 
 func checkArguments(argParser *parser.Parser) {
-	if args.NZBFile == "" {
-		writeUsage(argParser)
-		exit(fmt.Errorf("no path to NZB file provided"))
+	switch command {
+	case "history":
+		if historyArgs.NZBFile == "" {
+			writeUsage(argParser)
+			exit(fmt.Errorf("no path to NZB file provided"))
+		}
+		if historyArgs.History == "" {
+			historyArgs.History = "./history.jsonl"
+		}
+	case "schedule":
+		if scheduleArgs.Schedule == "" {
+			scheduleArgs.Schedule = "./schedule.json"
+		}
+		if scheduleArgs.Provider == "" {
+			scheduleArgs.Provider = "./provider.json"
+		}
+		if scheduleArgs.History == "" {
+			scheduleArgs.History = "./history.jsonl"
+		}
+		if scheduleArgs.Topology == "" {
+			scheduleArgs.Topology = "./topology.json"
+		}
+	case "serve":
+		if serveArgs.Listen == "" {
+			serveArgs.Listen = ":8080"
+		}
+		// jobs can reference any file on the server, so only local clients are accepted without a token
+		if serveArgs.Token == "" && !isLoopbackAddress(serveArgs.Listen) {
+			writeUsage(argParser)
+			exit(fmt.Errorf("no token provided, --token is required if the API server listens on '%s', which is not a loopback address", serveArgs.Listen))
+		}
+		if serveArgs.Provider == "" {
+			serveArgs.Provider = "./provider.json"
+		}
+		if serveArgs.UploadDir == "" {
+			serveArgs.UploadDir = filepath.Join(os.TempDir(), "nzbrefresh")
+		}
+		if serveArgs.History == "" {
+			serveArgs.History = "./history.jsonl"
+		}
+		if serveArgs.Topology == "" {
+			serveArgs.Topology = "./topology.json"
+		}
+	case "archive":
+		if archiveArgs.NZBFile == "" {
+			writeUsage(argParser)
+			exit(fmt.Errorf("no path to NZB file provided"))
+		}
+		if archiveArgs.Archive == "" {
+			archiveArgs.Archive = archiveFileName(archiveArgs.NZBFile)
+		}
+		if archiveArgs.Provider == "" {
+			archiveArgs.Provider = "./provider.json"
+		}
+		if archiveArgs.Topology == "" {
+			archiveArgs.Topology = "./topology.json"
+		}
+	case "post-spool":
+		if spoolArgs.Spool == "" {
+			writeUsage(argParser)
+			exit(fmt.Errorf("no path to the spool directory provided"))
+		}
+		if spoolArgs.Provider == "" {
+			spoolArgs.Provider = "./provider.json"
+		}
+		if spoolArgs.Topology == "" {
+			spoolArgs.Topology = "./topology.json"
+		}
+	case "providers":
+		switch providersArgs.Action {
+		case "test":
+		case "topology":
+			if providersArgs.NZBFile == "" {
+				writeUsage(argParser)
+				exit(fmt.Errorf("no path to NZB file provided, the topology is inferred from its articles"))
+			}
+		case "headers":
+			if providersArgs.NZBFile == "" {
+				writeUsage(argParser)
+				exit(fmt.Errorf("no path to NZB file provided, the headers preview uses one of its articles"))
+			}
+		default:
+			writeUsage(argParser)
+			exit(fmt.Errorf("unknown action '%s', the actions are 'test', 'topology' and 'headers'", providersArgs.Action))
+		}
+		if providersArgs.Provider == "" {
+			providersArgs.Provider = "./provider.json"
+		}
+		if providersArgs.Stat == 0 {
+			providersArgs.Stat = 100
+		}
+		if providersArgs.Sample == 0 {
+			providersArgs.Sample = 20
+		}
+		if providersArgs.Topology == "" {
+			providersArgs.Topology = "./topology.json"
+		}
+	default:
+		if args.NZBFile == "" {
+			writeUsage(argParser)
+			exit(fmt.Errorf("no path to NZB file provided"))
+		}
+
+		setDefaultArguments()
+
+		if args.Sample != "" {
+			if sampling, err = parseSample(args.Sample); err != nil {
+				writeUsage(argParser)
+				exit(err)
+			}
+			// re-uploading the sampled articles only would not repair the NZB file
+			args.CheckOnly = true
+		}
+
+		if args.Export != "" {
+			if args.CheckOnly || args.DryRun || args.NewMessageIDs {
+				writeUsage(argParser)
+				exit(fmt.Errorf("--export cannot be combined with --check, --sample, --dry-run or --new-message-ids"))
+			}
+			if err := os.MkdirAll(args.Export, 0755); err != nil {
+				exit(fmt.Errorf("unable to create the spool directory '%s': %v", args.Export, err))
+			}
+		}
+
+		if args.Archive != "" {
+			if _, err := os.Stat(args.Archive); err != nil {
+				exit(fmt.Errorf("unable to open the archive '%s': %v", args.Archive, err))
+			}
+		}
+
+		if args.SourceDir != "" {
+			if info, err := os.Stat(args.SourceDir); err != nil {
+				exit(fmt.Errorf("unable to open the source directory '%s': %v", args.SourceDir, err))
+			} else if !info.IsDir() {
+				exit(fmt.Errorf("the source directory '%s' is not a directory", args.SourceDir))
+			}
+		}
 	}
+}
 
+// setDefaultArguments sets the defaults of the arguments of the main command which are not set
+func setDefaultArguments() {
 	if args.Provider == "" {
 		args.Provider = "./provider.json"
 	}
+	if args.History == "" {
+		args.History = "./history.jsonl"
+	}
+	if args.Topology == "" {
+		args.Topology = "./topology.json"
+	}
 }
 
 func writeUsage(parser *parser.Parser) {
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+)
+
+// providers sharing the same backbone (and therefore the same articles)
+type backbone struct {
+	name      string
+	providers []*Provider
+}
+
+var (
+	backbones           []*backbone // backbones in the order of the provider list
+	backbonesConfigured bool        // true if at least one provider has a backbone set
+)
+
+// setupBackbones groups the providers by their backbone, providers without backbone are a backbone of their own
+func setupBackbones() {
+	backbones = nil
+	backbonesConfigured = false
+	byName := make(map[string]*backbone)
+	for n := range providerList {
+		provider := &providerList[n]
+		name := provider.Backbone
+		if name == "" {
+			name = provider.Name
+		} else {
+			backbonesConfigured = true
+		}
+		group, ok := byName[name]
+		if !ok {
+			group = &backbone{name: name}
+			byName[name] = group
+			backbones = append(backbones, group)
+		}
+		group.providers = append(group.providers, provider)
+		provider.group = group
+	}
+	for _, group := range backbones {
+		if len(group.providers) > 1 {
+			log.Printf("backbone '%s': %s", group.name, strings.Join(group.providerNames(), ", "))
+		}
+	}
+}
+
+func (b *backbone) providerNames() []string {
+	var names []string
+	for _, provider := range b.providers {
+		names = append(names, provider.Name)
+	}
+	return names
+}
+
+// backboneMembers returns the providers of the backbones of the given providers,
+// starting with the given providers themselves
+func backboneMembers(providers []*Provider) []*Provider {
+	var members []*Provider
+	seen := make(map[*Provider]bool)
+	for _, provider := range providers {
+		members = append(members, provider)
+		seen[provider] = true
+	}
+	for _, provider := range providers {
+		for _, member := range provider.group.providers {
+			if !seen[member] {
+				members = append(members, member)
+				seen[member] = true
+			}
+		}
+	}
+	return members
+}
+
+// uploadTargets returns the lists of providers to re-upload a missing article to.
+// Without backbones, one upload to any of the providers is enough, as it propagates to the others.
+// With backbones, the article is uploaded to one provider of each backbone missing the article.
+func uploadTargets(missingOn []*Provider) [][]*Provider {
+	if !backbonesConfigured {
+		return [][]*Provider{missingOn}
+	}
+	var targets [][]*Provider
+	seen := make(map[*backbone]bool)
+	for _, provider := range missingOn {
+		if !seen[provider.group] {
+			seen[provider.group] = true
+			targets = append(targets, backboneMembers([]*Provider{provider}))
+		}
+	}
+	return targets
+}
+
+// addCheckResult adds the result of the check of an article on the provider to the statistics of all the providers of its backbone,
+// as only one provider of the backbone is asked and the others share its articles
+func (p *Provider) addCheckResult(messageID string, fileName string, available bool) {
+	for _, member := range p.group.providers {
+		if member != p && member.isOffline() {
+			continue
+		}
+		member.articles.checked.Add(1)
+		if available {
+			member.articles.available.Add(1)
+			fileStatLock.Lock()
+			fileStat[fileName].available[member.Name]++
+			fileStatLock.Unlock()
+		} else {
+			member.articles.missing.Add(1)
+			member.addMissingFingerprint(messageID)
+		}
+	}
+}
+
+// addMissingFingerprint adds the hash of a missing message ID to the fingerprint of the missing articles of the provider,
+// which is equal for providers missing exactly the same articles
+func (p *Provider) addMissingFingerprint(messageID string) {
+	hash := fnv.New64a()
+	hash.Write([]byte(messageID))
+	p.articles.missingFingerprint.Add(hash.Sum64())
+}
+
+// backboneResults returns the result lines of the backbones with more than one provider
+func backboneResults() []string {
+	var results []string
+	for _, group := range backbones {
+		if len(group.providers) < 2 {
+			continue
+		}
+		// the providers share the results of the checks, apart from the ones offline for a part of the run
+		var checked, available, missing, refreshed uint64
+		for _, provider := range group.providers {
+			checked = max(checked, provider.articles.checked.Load())
+			available = max(available, provider.articles.available.Load())
+			missing = max(missing, provider.articles.missing.Load())
+			refreshed += provider.articles.refreshed.Load()
+		}
+		results = append(results, fmt.Sprintf("Results for backbone '%s' (%s): checked: %v | available: %v | missing: %v | refreshed: %v",
+			group.name, strings.Join(group.providerNames(), ", "), checked, available, missing, refreshed))
+	}
+	return results
+}
+
+// backboneSuggestions returns suggestions for providers of different backbones
+// which are missing exactly the same articles and therefore probably share the same backbone
+func backboneSuggestions() []string {
+	type missingSet struct {
+		count       uint64
+		fingerprint uint64
+	}
+	var sets []missingSet
+	providersBySet := make(map[missingSet][]*Provider)
+	compared := make(map[*backbone]bool)
+	for n := range providerList {
+		provider := &providerList[n]
+		// only providers with complete results can be compared, and only one provider of each backbone
+		if compared[provider.group] {
+			continue
+		}
+		if provider.articles.checked.Load() != runProgress.segmentsChecked.Load() || provider.articles.missing.Load() == 0 {
+			continue
+		}
+		compared[provider.group] = true
+		set := missingSet{provider.articles.missing.Load(), provider.articles.missingFingerprint.Load()}
+		if _, ok := providersBySet[set]; !ok {
+			sets = append(sets, set)
+		}
+		providersBySet[set] = append(providersBySet[set], provider)
+	}
+	var suggestions []string
+	for _, set := range sets {
+		if providers := providersBySet[set]; len(providers) > 1 {
+			var names []string
+			for _, provider := range providers {
+				names = append(names, fmt.Sprintf("'%s'", provider.Name))
+			}
+			suggestions = append(suggestions, fmt.Sprintf("Providers %s are missing exactly the same %v articles, they are probably on the same backbone (see the Backbone option)",
+				strings.Join(names, ", "), set.count))
+		}
+	}
+	return suggestions
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// prefix of the environment variables overriding the settings
+const envPrefix = "NZBREFRESH_"
+
+// unified config file structure
+type configFile struct {
+	Providers []Provider
+	Headers   []headerRule               // header rewrite rules for all providers
+	Settings  map[string]json.RawMessage // settings by the long name of the flag
+}
+
+// argument field of an arguments structure
+type argField struct {
+	name  string // long name of the flag
+	short string // short name of the flag (without the dash)
+	value reflect.Value
+}
+
+func loadConfigFile(path string) (*configFile, error) {
+	if file, err := os.ReadFile(path); err != nil {
+		return nil, err
+	} else {
+		if file, err = configToJSON(path, file); err != nil {
+			return nil, err
+		}
+		cfg := configFile{}
+		if err := json.Unmarshal(file, &cfg); err != nil {
+			return nil, err
+		}
+		return &cfg, nil
+	}
+}
+
+// configToJSON converts YAML and TOML config files to JSON, so all the formats are decoded the same way
+func configToJSON(path string, file []byte) ([]byte, error) {
+	var values interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(file, &values); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		tomlValues := make(map[string]interface{})
+		if err := toml.Unmarshal(file, &tomlValues); err != nil {
+			return nil, err
+		}
+		values = tomlValues
+	default:
+		return file, nil
+	}
+	return json.Marshal(values)
+}
+
+// isProviderArray returns true if the JSON is a bare list of providers (the format of the provider.json)
+func isProviderArray(file []byte) bool {
+	file = bytes.TrimSpace(file)
+	return len(file) > 0 && file[0] == '['
+}
+
+// applySettings sets the arguments which were not given on the command line,
+// first from the environment variables and then from the config file
+func applySettings(dest interface{}, cmdArgs []string) error {
+	fields := argFields(reflect.ValueOf(dest).Elem())
+
+	// fields set on the command line or by the environment, which must not be overwritten by the config file,
+	// even if they are set to the zero value (e.g. --check=false)
+	isSet := givenFlags(fields, cmdArgs)
+	for _, field := range fields {
+		if isSet[field.name] {
+			continue
+		}
+		name := envName(field.name)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := setArgValue(field.value, value); err != nil {
+				return fmt.Errorf("invalid value for environment variable '%s': %v", name, err)
+			}
+			isSet[field.name] = true
+		}
+	}
+
+	var configPath string
+	for _, field := range fields {
+		if field.name == "config" {
+			configPath = field.value.String()
+		}
+	}
+	if configPath == "" {
+		return nil
+	}
+	cfg, err := loadConfigFile(configPath)
+	if err != nil {
+		return fmt.Errorf("unable to load config file '%s': %v", configPath, err)
+	}
+	if err := checkSettings(cfg.Settings); err != nil {
+		return fmt.Errorf("invalid config file '%s': %v", configPath, err)
+	}
+	for _, field := range fields {
+		if isSet[field.name] {
+			continue
+		}
+		if raw, ok := cfg.Settings[field.name]; ok {
+			if err := json.Unmarshal(raw, field.value.Addr().Interface()); err != nil {
+				return fmt.Errorf("invalid value for setting '%s' in config file '%s': %v", field.name, configPath, err)
+			}
+		} else if field.name == "provider" && len(cfg.Providers) > 0 {
+			// use the providers section of the config file
+			field.value.SetString(configPath)
+		}
+	}
+	return nil
+}
+
+// checkSettings returns an error if a setting is not a flag of any of the commands
+func checkSettings(settings map[string]json.RawMessage) error {
+	known := make(map[string]bool)
+	for _, dest := range append([]interface{}{&args}, commandDests()...) {
+		for _, field := range argFields(reflect.ValueOf(dest).Elem()) {
+			known[field.name] = true
+		}
+	}
+	for _, name := range sortedKeys(settings) {
+		if !known[name] {
+			return fmt.Errorf("unknown setting '%s'", name)
+		}
+	}
+	return nil
+}
+
+func commandDests() []interface{} {
+	var dests []interface{}
+	for _, name := range sortedKeys(commands) {
+		dests = append(dests, commands[name])
+	}
+	return dests
+}
+
+// argFields returns the flags of an arguments structure, including the ones of embedded structures
+func argFields(v reflect.Value) []argField {
+	var fields []argField
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, argFields(v.Field(i))...)
+			continue
+		}
+		tag := field.Tag.Get("arg")
+		if strings.Contains(tag, "positional") {
+			continue
+		}
+		name, short := strings.ToLower(field.Name), ""
+		for _, part := range strings.Split(tag, ",") {
+			if part = strings.TrimSpace(part); strings.HasPrefix(part, "--") {
+				name = strings.TrimPrefix(part, "--")
+			} else if strings.HasPrefix(part, "-") {
+				short = strings.TrimPrefix(part, "-")
+			}
+		}
+		fields = append(fields, argField{name: name, short: short, value: v.Field(i)})
+	}
+	return fields
+}
+
+// givenFlags returns the long names of the flags given on the command line
+func givenFlags(fields []argField, cmdArgs []string) map[string]bool {
+	names := make(map[string]string)
+	for _, field := range fields {
+		names["--"+field.name] = field.name
+		if field.short != "" {
+			names["-"+field.short] = field.name
+		}
+	}
+	given := make(map[string]bool)
+	for _, arg := range cmdArgs {
+		if arg == "--" {
+			// only positional arguments follow
+			break
+		}
+		flag, _, _ := strings.Cut(arg, "=")
+		if name, ok := names[flag]; ok {
+			given[name] = true
+		}
+	}
+	return given
+}
+
+// envName returns the name of the environment variable for a flag (e.g. NZBREFRESH_NO_HISTORY for --no-history)
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func setArgValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(value); err != nil {
+			return err
+		} else {
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		if i, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return err
+		} else {
+			v.SetInt(i)
+		}
+	case reflect.Uint, reflect.Uint64, reflect.Uint32:
+		if u, err := strconv.ParseUint(value, 10, 64); err != nil {
+			return err
+		} else {
+			v.SetUint(u)
+		}
+	case reflect.Float64:
+		if f, err := strconv.ParseFloat(value, 64); err != nil {
+			return err
+		} else {
+			v.SetFloat(f)
+		}
+	case reflect.Slice:
+		// multiple values are separated by commas
+		values := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				item := reflect.New(v.Type().Elem()).Elem()
+				if err := setArgValue(item, part); err != nil {
+					return err
+				}
+				values = reflect.Append(values, item)
+			}
+		}
+		v.Set(values)
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// secrets which are replaced in the debug log
+var (
+	secrets     []string
+	secretsLock sync.RWMutex
+)
+
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// netrc entry of a machine
+type netrcEntry struct {
+	login    string
+	password string
+}
+
+// resolveCredentials interpolates the environment variables in the string fields of the provider
+// and loads the password from the environment, a file or the netrc file if it is not set directly
+func resolveCredentials(provider *Provider) error {
+	if err := interpolateEnvVars(provider); err != nil {
+		return err
+	}
+	if provider.Password == "" && provider.PasswordEnv != "" {
+		if password, ok := os.LookupEnv(provider.PasswordEnv); !ok {
+			return fmt.Errorf("environment variable '%s' is not set", provider.PasswordEnv)
+		} else {
+			provider.Password = password
+		}
+	}
+	if provider.Password == "" && provider.PasswordFile != "" {
+		if file, err := os.ReadFile(expandHome(provider.PasswordFile)); err != nil {
+			return fmt.Errorf("unable to read password file: %v", err)
+		} else {
+			provider.Password = strings.TrimRight(string(file), "\r\n")
+		}
+	}
+	if provider.Password == "" && provider.Host != "" {
+		if entry, err := lookupNetrc(provider.Host); err != nil {
+			return fmt.Errorf("unable to read netrc file: %v", err)
+		} else if entry != nil && (provider.Username == "" || provider.Username == entry.login) {
+			provider.Username = entry.login
+			provider.Password = entry.password
+		}
+	}
+	addSecret(provider.Password)
+	return nil
+}
+
+// interpolateEnvVars replaces ${VAR} in all the string fields with the value of the environment variable
+func interpolateEnvVars(provider *Provider) error {
+	v := reflect.ValueOf(provider).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() != reflect.String || !field.CanSet() {
+			continue
+		}
+		var missing []string
+		value := envVarPattern.ReplaceAllStringFunc(field.String(), func(match string) string {
+			name := envVarPattern.FindStringSubmatch(match)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return value
+		})
+		if len(missing) > 0 {
+			return fmt.Errorf("environment variable '%s' used in '%s' is not set", missing[0], v.Type().Field(i).Name)
+		}
+		field.SetString(value)
+	}
+	return nil
+}
+
+// lookupNetrc returns the entry of the machine (or the default entry) from the netrc file, nil if there is none
+func lookupNetrc(machine string) (*netrcEntry, error) {
+	path := os.Getenv("NETRC")
+	if path == "" {
+		if home, err := os.UserHomeDir(); err != nil {
+			return nil, nil
+		} else {
+			path = filepath.Join(home, ".netrc")
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var current, found, fallback *netrcEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "machine":
+			current = &netrcEntry{}
+			if scanner.Scan() && scanner.Text() == machine && found == nil {
+				found = current
+			}
+		case "default":
+			current = &netrcEntry{}
+			fallback = current
+		case "login":
+			if scanner.Scan() && current != nil {
+				current.login = scanner.Text()
+			}
+		case "password":
+			if scanner.Scan() && current != nil {
+				current.password = scanner.Text()
+			}
+		case "macdef":
+			// the words of macro definitions are ignored until the next machine
+			current = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if found != nil {
+		return found, nil
+	}
+	return fallback, nil
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+func addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// redactingWriter replaces the secrets in the output written to the debug log
+type redactingWriter struct {
+	out io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	secretsLock.RLock()
+	line := string(p)
+	for _, secret := range secrets {
+		line = strings.ReplaceAll(line, secret, "********")
+	}
+	secretsLock.RUnlock()
+	if _, err := io.WriteString(w.out, line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// static assets of the web dashboard compiled into the binary
+//
+//go:embed web
+var dashboardAssets embed.FS
+
+func dashboardHandler() http.Handler {
+	assets, err := fs.Sub(dashboardAssets, "web")
+	if err != nil {
+		// cannot happen as the directory is embedded at compile time
+		panic(err)
+	}
+	return http.FileServer(http.FS(assets))
+}
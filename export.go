@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Tensai75/nntp"
+	"github.com/Tensai75/nzbparser"
+)
+
+// name of the index file of a spool directory
+const spoolIndexName = "index.jsonl"
+
+// entry of the index of a spool directory, one JSON record per exported article
+type spoolEntry struct {
+	MessageID string    `json:"messageId"`
+	Path      string    `json:"path"` // file name of the article in the spool directory
+	Nzb       string    `json:"nzb"`
+	File      string    `json:"file"`
+	Number    int       `json:"number"`
+	Bytes     int       `json:"bytes"`
+	MissingOn []string  `json:"missingOn"` // providers missing the article at the time of the export
+	Time      time.Time `json:"time"`
+}
+
+var (
+	exported  atomic.Uint64 // number of articles exported in the current run
+	spoolLock sync.Mutex
+)
+
+// spoolFileName returns the file name of the article in the spool directory
+func spoolFileName(messageID string) string {
+	hash := sha256.Sum256([]byte(messageID))
+	return hex.EncodeToString(hash[:16]) + ".msg"
+}
+
+// exportArticle writes the article as raw message to the spool directory and adds it to the index
+func exportArticle(article *nntp.Article, segment nzbparser.NzbSegment, fileName string, missingOn []*Provider) error {
+	body, err := io.ReadAll(article.Body)
+	if err != nil {
+		return fmt.Errorf("unable to export article <%s>: %v", segment.Id, err)
+	}
+	article.Body = bytes.NewReader(body)
+	message := rawArticle(article.Header, body)
+
+	entry := spoolEntry{
+		MessageID: segment.Id,
+		Path:      spoolFileName(segment.Id),
+		Nzb:       filepath.Base(args.NZBFile),
+		File:      fileName,
+		Number:    segment.Number,
+		Bytes:     len(message),
+		Time:      time.Now(),
+	}
+	for _, provider := range missingOn {
+		entry.MissingOn = append(entry.MissingOn, provider.Name)
+	}
+	sort.Strings(entry.MissingOn)
+
+	if err := os.WriteFile(filepath.Join(args.Export, entry.Path), message, 0644); err != nil {
+		return fmt.Errorf("unable to export article <%s>: %v", segment.Id, err)
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	spoolLock.Lock()
+	defer spoolLock.Unlock()
+	f, err := os.OpenFile(filepath.Join(args.Export, spoolIndexName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write to the spool index: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("unable to write to the spool index: %v", err)
+	}
+	exported.Add(1)
+	log.Printf("article <%s> exported to '%s'", segment.Id, filepath.Join(args.Export, entry.Path))
+	return nil
+}
+
+// rawArticle returns the article with headers and body and CRLF line endings as in RFC 5536
+func rawArticle(header map[string][]string, body []byte) []byte {
+	var message bytes.Buffer
+	headers := make([]string, 0, len(header))
+	for name := range header {
+		headers = append(headers, name)
+	}
+	sort.Strings(headers)
+	for _, name := range headers {
+		for _, value := range header[name] {
+			fmt.Fprintf(&message, "%s: %s\r\n", name, value)
+		}
+	}
+	message.WriteString("\r\n")
+	message.Write(bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n")))
+	return message.Bytes()
+}
+
+// exportResult returns the result line of the export
+func exportResult() string {
+	return fmt.Sprintf("%v articles exported to the spool directory '%s'", exported.Load(), args.Export)
+}
@@ -3,12 +3,14 @@ module github.com/Tensai75/nzbrefresh
 go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/Tensai75/cmpb v0.0.0-20240707075110-16fb79fca928
 	github.com/Tensai75/nntp v0.1.2
 	github.com/Tensai75/nntpPool v0.1.2
 	github.com/Tensai75/nzbparser v0.1.0
 	github.com/alexflint/go-arg v1.5.1
 	github.com/fatih/color v1.17.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tensai75/nntp"
+)
+
+// time after which the active groups of a provider are fetched again (e.g. by the schedule or serve command)
+const activeGroupsMaxAge = 24 * time.Hour
+
+// time after which the active groups are fetched again if they could not be fetched due to a connection error
+const activeGroupsRetryAge = 5 * time.Minute
+
+// groups of a provider as fetched with LIST ACTIVE
+type activeGroups struct {
+	lock    sync.Mutex      // held while the groups are fetched, so the other articles wait for the result
+	groups  map[string]bool // true if posting to the group is allowed
+	fetched time.Time
+	err     error
+	maxAge  time.Duration
+}
+
+var (
+	activeGroupsCache = make(map[string]*activeGroups) // by provider name
+	activeGroupsLock  sync.Mutex
+
+	postedGroups     = make(map[string]map[string]uint64) // number of articles re-posted by provider name and newsgroups
+	postedGroupsLock sync.Mutex
+)
+
+// activeGroups returns the groups of the provider, which are fetched once and cached
+func (p *Provider) activeGroups() (map[string]bool, error) {
+	activeGroupsLock.Lock()
+	cached, ok := activeGroupsCache[p.Name]
+	if !ok {
+		cached = &activeGroups{}
+		activeGroupsCache[p.Name] = cached
+	}
+	activeGroupsLock.Unlock()
+
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+	if !cached.fetched.IsZero() && time.Since(cached.fetched) < cached.maxAge {
+		return cached.groups, cached.err
+	}
+	groups, err := p.fetchActiveGroups()
+	cached.groups, cached.fetched, cached.err, cached.maxAge = groups, time.Now(), err, activeGroupsMaxAge
+	var nntpError nntp.Error
+	if err != nil {
+		log.Print(fmt.Errorf("unable to load the active groups of provider '%s', the groups cannot be verified: %v", p.Name, err))
+		// connection errors are only cached for a short time, so the groups are fetched again later
+		if !errors.As(err, &nntpError) {
+			cached.maxAge = activeGroupsRetryAge
+		}
+	} else {
+		log.Printf("loaded %v active groups of provider '%s'", len(groups), p.Name)
+	}
+	return groups, err
+}
+
+func (p *Provider) fetchActiveGroups() (map[string]bool, error) {
+	conn, pool, err := p.getConn()
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Put(conn)
+	lines, err := conn.List("ACTIVE")
+	if err != nil {
+		return nil, err
+	}
+	// each line is "group high low status", with status "y" if posting is allowed
+	groups := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) >= 4 {
+			groups[strings.ToLower(fields[0])] = fields[3] == "y"
+		} else if len(fields) > 0 {
+			groups[strings.ToLower(fields[0])] = true
+		}
+	}
+	return groups, nil
+}
+
+// postGroups returns the newsgroups to post the article to on the provider:
+// the groups the provider doesn't carry are replaced by their alternate group or removed,
+// and if none of the groups is left, the fallback groups of the provider are used
+func (p *Provider) postGroups(newsgroups string) (string, bool, error) {
+	active, err := p.activeGroups()
+	if err != nil {
+		// post to the original groups if the groups of the provider are unknown
+		return newsgroups, false, nil
+	}
+	var groups []string
+	for _, group := range splitGroups(newsgroups) {
+		if !active[strings.ToLower(group)] {
+			if alternate, ok := p.AlternateGroups[group]; ok && active[strings.ToLower(alternate)] {
+				group = alternate
+			} else {
+				continue
+			}
+		}
+		if !slices.Contains(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) > 0 {
+		return strings.Join(groups, ","), false, nil
+	}
+	for _, group := range p.FallbackGroups {
+		if active[strings.ToLower(group)] {
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) > 0 {
+		return strings.Join(groups, ","), true, nil
+	}
+	return "", false, fmt.Errorf("provider '%s' doesn't allow posting to any of the groups '%s' nor to a fallback group", p.Name, newsgroups)
+}
+
+func splitGroups(newsgroups string) []string {
+	var groups []string
+	for _, group := range strings.Split(newsgroups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// verifyNzbGroups checks whether the providers used for re-uploading carry the groups of the NZB file
+func verifyNzbGroups() {
+	var nzbGroups []string
+	for _, file := range nzbfile.Files {
+		for _, group := range file.Groups {
+			if !slices.Contains(nzbGroups, group) {
+				nzbGroups = append(nzbGroups, group)
+			}
+		}
+	}
+	for n := range providerList {
+		provider := &providerList[n]
+		if !provider.canUpload() || provider.isOffline() {
+			continue
+		}
+		if groups, _, err := provider.postGroups(strings.Join(nzbGroups, ",")); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			log.Print(err)
+		} else if groups != strings.Join(nzbGroups, ",") {
+			warning := fmt.Sprintf("provider '%s' doesn't carry all groups of the NZB file (%s), articles are re-posted to '%s'", provider.Name, strings.Join(nzbGroups, ","), groups)
+			fmt.Printf("Warning: %s\n", warning)
+			log.Print(warning)
+		}
+	}
+}
+
+// setPostGroups sets the Newsgroups header of the article to the groups the provider allows posting to
+func setPostGroups(provider *Provider, header map[string][]string) error {
+	newsgroups := ""
+	if values := header["Newsgroups"]; len(values) > 0 {
+		newsgroups = values[0]
+	}
+	groups, fallback, err := provider.postGroups(newsgroups)
+	if err != nil {
+		return err
+	}
+	if groups != newsgroups {
+		log.Printf("article %s is re-posted to provider '%s' in groups '%s' instead of '%s' (fallback: %v)", firstValue(header["Message-Id"]), provider.Name, groups, newsgroups, fallback)
+	}
+	header["Newsgroups"] = []string{groups}
+	return nil
+}
+
+// countPostedGroups counts the articles successfully re-posted to the groups on the provider
+func countPostedGroups(provider *Provider, header map[string][]string) {
+	postedGroupsLock.Lock()
+	defer postedGroupsLock.Unlock()
+	if postedGroups[provider.Name] == nil {
+		postedGroups[provider.Name] = make(map[string]uint64)
+	}
+	postedGroups[provider.Name][firstValue(header["Newsgroups"])]++
+}
+
+func resetPostedGroups() {
+	postedGroupsLock.Lock()
+	defer postedGroupsLock.Unlock()
+	postedGroups = make(map[string]map[string]uint64)
+}
+
+// postedGroupsResults returns the result lines with the groups the articles were re-posted to
+func postedGroupsResults() []string {
+	postedGroupsLock.Lock()
+	defer postedGroupsLock.Unlock()
+	var results []string
+	for n := range providerList {
+		counts, ok := postedGroups[providerList[n].Name]
+		if !ok {
+			continue
+		}
+		var groups []string
+		for _, newsgroups := range sortedKeys(counts) {
+			groups = append(groups, fmt.Sprintf("%s: %v", newsgroups, counts[newsgroups]))
+		}
+		results = append(results, fmt.Sprintf("Groups of the articles re-posted to '%s': %s", providerList[n].Name, strings.Join(groups, " | ")))
+	}
+	return results
+}
+
+func firstValue(values []string) string {
+	if len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
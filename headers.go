@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/textproto"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Tensai75/nntp"
+)
+
+// header rewrite rule for re-posted articles
+type headerRule struct {
+	Header string // header name, may contain wildcards (e.g. "X-*")
+	Action string // keep, drop, set or template
+	Value  string // value of the set action or Go template of the template action
+
+	template *template.Template
+}
+
+// data available in the templates of the header rules
+type headerTemplateData struct {
+	Header    map[string]string // original headers of the article
+	MessageID string
+	Provider  string
+	Now       string // current date in the format of the Date header
+}
+
+// headers kept by default, as they are required for the post
+var defaultHeaders = []string{
+	"From",
+	"Subject",
+	"Newsgroups",
+	"Message-Id",
+	"Date",
+	"Path",
+}
+
+// compileHeaderRules checks the header rules of the provider and parses their templates
+func compileHeaderRules(provider *Provider) error {
+	for n := range provider.Headers {
+		rule := &provider.Headers[n]
+		if rule.Header == "" {
+			return fmt.Errorf("header rule %v: no header name", n+1)
+		}
+		if _, err := path.Match(strings.ToLower(rule.Header), ""); err != nil {
+			return fmt.Errorf("header rule %v: invalid header name '%s': %v", n+1, rule.Header, err)
+		}
+		switch strings.ToLower(rule.Action) {
+		case "keep", "drop", "set":
+		case "template":
+			if tmpl, err := template.New(rule.Header).Option("missingkey=zero").Parse(rule.Value); err != nil {
+				return fmt.Errorf("header rule %v: invalid template for header '%s': %v", n+1, rule.Header, err)
+			} else {
+				rule.template = tmpl
+			}
+		default:
+			return fmt.Errorf("header rule %v: unknown action '%s', the actions are keep, drop, set and template", n+1, rule.Action)
+		}
+	}
+	return nil
+}
+
+// matches returns true if the rule applies to the header
+func (rule *headerRule) matches(header string) bool {
+	matched, _ := path.Match(strings.ToLower(rule.Header), strings.ToLower(header))
+	return matched
+}
+
+// isPattern returns true if the rule applies to several headers, so it cannot add a header
+func (rule *headerRule) isPattern() bool {
+	return strings.ContainsAny(rule.Header, "*?[")
+}
+
+// value returns the new value of the header for the set and template actions
+func (rule *headerRule) value(data *headerTemplateData) (string, error) {
+	if rule.template == nil {
+		return rule.Value, nil
+	}
+	var value bytes.Buffer
+	if err := rule.template.Execute(&value, data); err != nil {
+		return "", fmt.Errorf("unable to execute the template for header '%s': %v", rule.Header, err)
+	}
+	return strings.TrimSpace(value.String()), nil
+}
+
+// rewriteHeaders rewrites the headers of the article for the post to the provider.
+// The first header rule of the provider matching a header applies, headers without matching rule are handled by default:
+// only the headers required for the post are kept, the Path is set to "not-for-mail" and the Date is set to now.
+// The Message-Id is never changed by the rules.
+func rewriteHeaders(article *nntp.Article, provider *Provider) error {
+	data := &headerTemplateData{
+		Header:   make(map[string]string),
+		Provider: provider.Name,
+		Now:      time.Now().Format(time.RFC1123Z),
+	}
+	for header, values := range article.Header {
+		if len(values) > 0 {
+			data.Header[header] = values[0]
+		}
+	}
+	data.MessageID = strings.Trim(data.Header["Message-Id"], "<>")
+
+	for header := range article.Header {
+		if header == "Message-Id" {
+			continue
+		}
+		ruleIndex := slices.IndexFunc(provider.Headers, func(rule headerRule) bool { return rule.matches(header) })
+		if ruleIndex < 0 {
+			// default handling
+			if !slices.Contains(defaultHeaders, header) {
+				delete(article.Header, header)
+			} else if header == "Path" {
+				article.Header[header] = []string{"not-for-mail"}
+			} else if header == "Date" {
+				article.Header[header] = []string{data.Now}
+			}
+			continue
+		}
+		rule := &provider.Headers[ruleIndex]
+		switch strings.ToLower(rule.Action) {
+		case "drop":
+			delete(article.Header, header)
+		case "set", "template":
+			if value, err := rule.value(data); err != nil {
+				return err
+			} else {
+				article.Header[header] = []string{value}
+			}
+		}
+	}
+
+	// set and template rules add their header if the article doesn't have it
+	added := make(map[string]bool)
+	for n := range provider.Headers {
+		rule := &provider.Headers[n]
+		action := strings.ToLower(rule.Action)
+		if rule.isPattern() || (action != "set" && action != "template") {
+			continue
+		}
+		header := textproto.CanonicalMIMEHeaderKey(rule.Header)
+		if _, ok := data.Header[header]; ok || added[header] || header == "Message-Id" {
+			continue
+		}
+		if value, err := rule.value(data); err != nil {
+			return err
+		} else {
+			article.Header[header] = []string{value}
+			added[header] = true
+		}
+	}
+	return nil
+}
+
+// runProvidersHeaders shows the headers of an article of the NZB file as they would be re-posted to each provider,
+// so the header rules can be checked without posting anything
+func runProvidersHeaders() {
+	setupLogging("providers", providersArgs.Debug)
+
+	if providerList, err = loadProviderList(providersArgs.Provider); err != nil {
+		exit(fmt.Errorf("unable to load provider list: %v", err))
+	}
+	nzb, err := loadNzbFile(providersArgs.NZBFile)
+	if err != nil {
+		exit(fmt.Errorf("unable to load NZB file '%s': %v", providersArgs.NZBFile, err))
+	}
+
+	// the headers of the first article available on any of the providers
+	var article *nntp.Article
+	var source *Provider
+	for _, file := range nzb.Files {
+		for _, segment := range file.Segments {
+			for n := range providerList {
+				if article, err = fetchHead(&providerList[n], segment.Id); err != nil {
+					log.Print(fmt.Errorf("unable to load the header of article <%s> from provider '%s': %v", segment.Id, providerList[n].Name, err))
+					continue
+				}
+				source = &providerList[n]
+				break
+			}
+			if article != nil {
+				break
+			}
+		}
+		if article != nil {
+			break
+		}
+	}
+	if article == nil {
+		exit(fmt.Errorf("none of the articles of the NZB file is available on any provider"))
+	}
+
+	fmt.Printf("Original headers (from provider '%s'):\n", source.Name)
+	printHeaders(article.Header)
+	for n := range providerList {
+		provider := &providerList[n]
+		rewritten := &nntp.Article{Header: make(map[string][]string)}
+		for header, values := range article.Header {
+			rewritten.Header[header] = slices.Clone(values)
+		}
+		fmt.Printf("\nHeaders re-posted to provider '%s' (%v rules):\n", provider.Name, len(provider.Headers))
+		if err := rewriteHeaders(rewritten, provider); err != nil {
+			fmt.Printf("   error: %v\n", err)
+			continue
+		}
+		printHeaders(rewritten.Header)
+	}
+}
+
+// fetchHead loads the headers of the article from the provider
+func fetchHead(provider *Provider, messageID string) (*nntp.Article, error) {
+	conn, err := dialProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Quit()
+	if err := conn.Authenticate(provider.Username, provider.Password); err != nil {
+		return nil, err
+	}
+	return conn.Head("<" + messageID + ">")
+}
+
+func printHeaders(headers map[string][]string) {
+	names := make([]string, 0, len(headers))
+	for header := range headers {
+		names = append(names, header)
+	}
+	sort.Strings(names)
+	for _, header := range names {
+		for _, value := range headers[header] {
+			fmt.Printf("   %s: %s\n", header, value)
+		}
+	}
+}
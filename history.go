@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Tensai75/nzbparser"
+)
+
+type (
+	// one run of nzbrefresh as stored in the history database
+	historyRecord struct {
+		NzbID         string            `json:"nzbId"`
+		NzbName       string            `json:"nzbName"`
+		Time          time.Time         `json:"time"`
+		CheckOnly     bool              `json:"checkOnly"`
+		Sampled       bool              `json:"sampled,omitempty"` // only a sample of the segments was checked
+		Aborted       bool              `json:"aborted,omitempty"` // the run was aborted as the NZB file is beyond saving
+		TotalSegments int               `json:"totalSegments"`
+		Providers     []historyProvider `json:"providers"`
+		Files         []historyFile     `json:"files,omitempty"`
+	}
+	historyProvider struct {
+		Name      string `json:"name"`
+		Checked   uint64 `json:"checked"`
+		Available uint64 `json:"available"`
+		Missing   uint64 `json:"missing"`
+		Refreshed uint64 `json:"refreshed"`
+		Offline   bool   `json:"offline,omitempty"` // provider was offline during the run
+	}
+	historyFile struct {
+		Name          string            `json:"name"`
+		TotalSegments uint64            `json:"totalSegments"`
+		Available     providerStatistic `json:"available"`
+	}
+)
+
+// nzbIdentity returns an identifier for the NZB file which does not depend on
+// its file name, so renamed copies of the same NZB share the same history
+func nzbIdentity(nzb *nzbparser.Nzb) string {
+	ids := make([]string, 0, nzb.Segments)
+	for _, file := range nzb.Files {
+		for _, segment := range file.Segments {
+			ids = append(ids, segment.Id)
+		}
+	}
+	sort.Strings(ids)
+	hash := sha256.Sum256([]byte(strings.Join(ids, "\n")))
+	return hex.EncodeToString(hash[:16])
+}
+
+// availability returns the share of checked articles which were available in percent
+func (p historyProvider) availability() (float64, bool) {
+	if p.Checked == 0 {
+		return 0, false
+	}
+	return float64(p.Available) / float64(p.Checked) * 100, true
+}
+
+// runReport returns the results of the current run as history record
+func runReport(path string) historyRecord {
+	record := historyRecord{
+		NzbID:         nzbIdentity(nzbfile),
+		NzbName:       filepath.Base(path),
+		Time:          segmentCheckStartTime,
+		CheckOnly:     args.CheckOnly,
+		Sampled:       sampling != nil,
+		Aborted:       earlyAbort.aborted.Load(),
+		TotalSegments: nzbfile.TotalSegments,
+	}
+	for n := range providerList {
+		record.Providers = append(record.Providers, historyProvider{
+			Name:      providerList[n].Name,
+			Checked:   providerList[n].articles.checked.Load(),
+			Available: providerList[n].articles.available.Load(),
+			Missing:   providerList[n].articles.missing.Load(),
+			Refreshed: providerList[n].articles.refreshed.Load(),
+			Offline:   providerList[n].articles.offline.Load(),
+		})
+	}
+	fileStatLock.Lock()
+	for fileName, file := range fileStat {
+		// copy the statistic as it might still be updated by a running check
+		available := make(providerStatistic, len(file.available))
+		for providerName, value := range file.available {
+			available[providerName] = value
+		}
+		record.Files = append(record.Files, historyFile{
+			Name:          fileName,
+			TotalSegments: file.totalSegments,
+			Available:     available,
+		})
+	}
+	fileStatLock.Unlock()
+	sort.Slice(record.Files, func(i, j int) bool { return record.Files[i].Name < record.Files[j].Name })
+	return record
+}
+
+func writeHistory(path string) {
+	if args.NoHistory {
+		return
+	}
+	if err := appendHistoryRecord(args.History, runReport(path)); err != nil {
+		// a failing history must not fail the run
+		fmt.Printf("Unable to write to the history database: %v\n", err)
+		log.Print(fmt.Errorf("unable to write to the history database: %v", err))
+	} else {
+		log.Printf("results written to the history database '%s'", args.History)
+	}
+}
+
+func appendHistoryRecord(path string, record historyRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// loadHistory returns all records of the history database for the given NZB identity sorted by time
+func loadHistory(path string, nzbID string) ([]historyRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []historyRecord
+	decoder := json.NewDecoder(f)
+	for {
+		var record historyRecord
+		if err := decoder.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if nzbID == "" || record.NzbID == nzbID {
+			records = append(records, record)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+func showHistory() {
+	nzb, err := loadNzbFile(historyArgs.NZBFile)
+	if err != nil {
+		exit(fmt.Errorf("unable to load NZB file '%s': %v'", historyArgs.NZBFile, err))
+	}
+	records, err := loadHistory(historyArgs.History, nzbIdentity(nzb))
+	if err != nil {
+		exit(fmt.Errorf("unable to load the history database: %v", err))
+	}
+	if len(records) == 0 {
+		fmt.Printf("No runs recorded for '%s'\n", filepath.Base(historyArgs.NZBFile))
+		return
+	}
+
+	// make sorted provider name slice over all runs
+	var providers []string
+	for _, record := range records {
+		for _, provider := range record.Providers {
+			if !slices.Contains(providers, provider.Name) {
+				providers = append(providers, provider.Name)
+			}
+		}
+	}
+	sort.Strings(providers)
+
+	fmt.Printf("History for '%s' (%v runs, %v segments)\n", filepath.Base(historyArgs.NZBFile), len(records), nzb.TotalSegments)
+	fmt.Printf("%-19s", "Date")
+	for _, providerName := range providers {
+		fmt.Printf(" | %12s", truncate(providerName, 12))
+	}
+	fmt.Println()
+	for _, record := range records {
+		fmt.Printf("%-19s", record.Time.Local().Format("2006-01-02 15:04:05"))
+		for _, providerName := range providers {
+			value := "-"
+			for _, provider := range record.Providers {
+				if provider.Name == providerName {
+					if availability, ok := provider.availability(); ok {
+						value = fmt.Sprintf("%.2f%%", availability)
+					}
+				}
+			}
+			fmt.Printf(" | %12s", value)
+		}
+		fmt.Println()
+	}
+
+	// retention trend between the first and the last run of each provider
+	fmt.Println("Retention trend:")
+	for _, providerName := range providers {
+		var first, last *historyRecord
+		var firstValue, lastValue float64
+		for n := range records {
+			for _, provider := range records[n].Providers {
+				if provider.Name == providerName {
+					if availability, ok := provider.availability(); ok {
+						if first == nil {
+							first, firstValue = &records[n], availability
+						}
+						last, lastValue = &records[n], availability
+					}
+				}
+			}
+		}
+		if first == nil || first == last {
+			fmt.Printf("   '%s': not enough runs\n", providerName)
+			continue
+		}
+		days := last.Time.Sub(first.Time).Hours() / 24
+		change := lastValue - firstValue
+		if days >= 1 {
+			fmt.Printf("   '%s': %+.2f%% over %.1f days (%+.3f%% per day)\n", providerName, change, days, change/days)
+		} else {
+			fmt.Printf("   '%s': %+.2f%%\n", providerName, change)
+		}
+	}
+
+	if historyArgs.Csv {
+		writeHistoryCsvFile(records)
+	}
+}
+
+func writeHistoryCsvFile(records []historyRecord) {
+	csvFileName := strings.TrimSuffix(filepath.Base(historyArgs.NZBFile), filepath.Ext(filepath.Base(historyArgs.NZBFile))) + "_history.csv"
+	f, err := os.Create(csvFileName)
+	if err != nil {
+		exit(fmt.Errorf("unable to open csv file: %v", err))
+	}
+	defer f.Close()
+	fmt.Print("Writing csv file... ")
+	csvWriter := csv.NewWriter(f)
+	if err := csvWriter.Write([]string{"Date", "Provider", "Checked", "Available", "Missing", "Refreshed"}); err != nil {
+		exit(fmt.Errorf("unable to write to the csv file: %v", err))
+	}
+	for _, record := range records {
+		for _, provider := range record.Providers {
+			line := []string{
+				record.Time.Format(time.RFC3339),
+				provider.Name,
+				fmt.Sprintf("%v", provider.Checked),
+				fmt.Sprintf("%v", provider.Available),
+				fmt.Sprintf("%v", provider.Missing),
+				fmt.Sprintf("%v", provider.Refreshed),
+			}
+			if err := csvWriter.Write(line); err != nil {
+				exit(fmt.Errorf("unable to write to the csv file: %v", err))
+			}
+		}
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		exit(fmt.Errorf("unable to write to the csv file: %v", err))
+	}
+	fmt.Println("done")
+}
+
+func truncate(text string, length int) string {
+	if len(text) > length {
+		return text[:length]
+	}
+	return text
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Tensai75/nntp"
+)
+
+// The IHAVE command of the nntp library doesn't send the message ID required by RFC 3977,
+// so the articles are offered with IHAVE on dedicated connections.
+// They count against the MaxConns of the provider and each command has to complete within ihaveTimeout.
+const ihaveTimeout = 60 * time.Second
+
+type ihaveConn struct {
+	*textproto.Conn
+	netConn net.Conn
+}
+
+var (
+	ihaveConns     = make(map[*Provider][]*ihaveConn) // idle IHAVE connections of each provider
+	ihaveOpen      = make(map[*Provider]uint32)       // number of open IHAVE connections of each provider
+	ihaveConnsLock sync.Mutex
+	ihaveConnsCond = sync.NewCond(&ihaveConnsLock) // signaled when a connection becomes idle or is closed
+)
+
+// canUpload returns whether the article can be uploaded to the provider with POST or IHAVE
+func (p *Provider) canUpload() bool {
+	return p.capabilities.post.Load() || (useIHave && p.capabilities.ihave.Load())
+}
+
+// getIHaveConn returns an idle IHAVE connection of the provider (unless a new one is requested) or opens a new one.
+// If the provider has all its connections open, it waits for one of them to become idle.
+func getIHaveConn(provider *Provider, idle bool) (*ihaveConn, error) {
+	ihaveConnsLock.Lock()
+	for {
+		conns := ihaveConns[provider]
+		if len(conns) > 0 && (idle || ihaveOpen[provider] >= ihaveLimit(provider)) {
+			conn := conns[len(conns)-1]
+			ihaveConns[provider] = conns[:len(conns)-1]
+			ihaveConnsLock.Unlock()
+			return conn, nil
+		}
+		if ihaveOpen[provider] < ihaveLimit(provider) {
+			break
+		}
+		ihaveConnsCond.Wait()
+	}
+	ihaveOpen[provider]++
+	ihaveConnsLock.Unlock()
+
+	conn, err := dialIHaveConn(provider)
+	if err != nil {
+		releaseIHaveConn(provider)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ihaveLimit returns the number of IHAVE connections of the provider,
+// which are the connections not opened by its connection pool (but at least one)
+func ihaveLimit(provider *Provider) uint32 {
+	limit := max(provider.MaxConns, 1)
+	if pool := provider.getPool(); pool != nil {
+		if _, open := pool.Conns(); open < limit {
+			limit -= open
+		} else {
+			limit = 1
+		}
+	}
+	return max(limit, 1)
+}
+
+func dialIHaveConn(provider *Provider) (*ihaveConn, error) {
+	address := fmt.Sprintf("%v:%v", provider.Host, provider.Port)
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var netConn net.Conn
+	var err error
+	if provider.SSL {
+		netConn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{InsecureSkipVerify: provider.SkipSslCheck})
+	} else {
+		netConn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := &ihaveConn{Conn: textproto.NewConn(netConn), netConn: netConn}
+	conn.setDeadline()
+	if _, _, err := conn.ReadCodeLine(20); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if provider.Username != "" {
+		if err := conn.PrintfLine("AUTHINFO USER %s", provider.Username); err == nil {
+			if _, _, err = conn.ReadCodeLine(381); err == nil {
+				if err = conn.PrintfLine("AUTHINFO PASS %s", provider.Password); err == nil {
+					_, _, err = conn.ReadCodeLine(281)
+				}
+			}
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to authenticate: %v", err)
+		}
+	}
+	return conn, nil
+}
+
+// setDeadline sets the deadline of the next command, so a stalled server doesn't block the upload forever
+func (conn *ihaveConn) setDeadline() {
+	conn.netConn.SetDeadline(time.Now().Add(ihaveTimeout))
+}
+
+func putIHaveConn(provider *Provider, conn *ihaveConn) {
+	ihaveConnsLock.Lock()
+	defer ihaveConnsLock.Unlock()
+	ihaveConns[provider] = append(ihaveConns[provider], conn)
+	ihaveConnsCond.Broadcast()
+}
+
+// closeIHaveConn closes a connection which is not usable anymore
+func closeIHaveConn(provider *Provider, conn *ihaveConn) {
+	conn.Close()
+	releaseIHaveConn(provider)
+}
+
+func releaseIHaveConn(provider *Provider) {
+	ihaveConnsLock.Lock()
+	defer ihaveConnsLock.Unlock()
+	ihaveOpen[provider]--
+	ihaveConnsCond.Broadcast()
+}
+
+func closeIHaveConns() {
+	ihaveConnsLock.Lock()
+	defer ihaveConnsLock.Unlock()
+	for provider, conns := range ihaveConns {
+		for _, conn := range conns {
+			conn.setDeadline()
+			conn.PrintfLine("QUIT")
+			conn.Close()
+		}
+		ihaveOpen[provider] -= uint32(len(conns))
+		delete(ihaveConns, provider)
+	}
+	ihaveConnsCond.Broadcast()
+}
+
+// ihaveArticle offers the article to the provider with the IHAVE command.
+// Refusals of the server are returned as nntp.Error, so they are not counted as connection errors.
+func ihaveArticle(provider *Provider, article *nntp.Article) error {
+	messageID := firstValue(article.Header["Message-Id"])
+	if messageID == "" {
+		return fmt.Errorf("article has no message ID")
+	}
+	body, err := io.ReadAll(article.Body)
+	if err != nil {
+		return err
+	}
+	// an idle connection may have been closed by the server in the meantime, so the second attempt uses a new connection
+	for attempt := 1; ; attempt++ {
+		conn, err := getIHaveConn(provider, attempt == 1)
+		if err != nil {
+			return err
+		}
+		err = sendIHave(conn, messageID, article.Header, body)
+		var protoErr *textproto.Error
+		if err == nil {
+			putIHaveConn(provider, conn)
+			return nil
+		} else if errors.As(err, &protoErr) {
+			// the connection stays usable after the server refused the article
+			putIHaveConn(provider, conn)
+			return nntp.Error{Code: uint(protoErr.Code), Msg: protoErr.Msg}
+		}
+		closeIHaveConn(provider, conn)
+		if attempt == 2 {
+			return err
+		}
+	}
+}
+
+func sendIHave(conn *ihaveConn, messageID string, header map[string][]string, body []byte) error {
+	conn.setDeadline()
+	if err := conn.PrintfLine("IHAVE %s", messageID); err != nil {
+		return err
+	}
+	if _, _, err := conn.ReadCodeLine(335); err != nil {
+		return err
+	}
+	// the dot writer converts the line endings to CRLF and escapes the lines starting with a dot
+	w := conn.DotWriter()
+	headers := make([]string, 0, len(header))
+	for name := range header {
+		headers = append(headers, name)
+	}
+	sort.Strings(headers)
+	for _, name := range headers {
+		for _, value := range header[name] {
+			if _, err := fmt.Fprintf(w, "%s: %s\n", name, value); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	_, _, err := conn.ReadCodeLine(235)
+	return err
+}
@@ -2,15 +2,16 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -33,24 +34,43 @@ type (
 		SkipSslCheck          bool
 		Username              string
 		Password              string
+		PasswordEnv           string // environment variable holding the password
+		PasswordFile          string // file holding the password
 		MaxConns              uint32
 		ConnWaitTime          time.Duration
 		IdleTimeout           time.Duration
 		HealthCheck           bool
 		MaxTooManyConnsErrors uint32
 		MaxConnErrors         uint32
+		Backbone              string            // providers of the same backbone share their articles
+		ReconnectWaitTime     time.Duration     // waiting time until reconnecting to an offline provider (0 = stay offline)
+		Headers               []headerRule      // header rewrite rules for re-posted articles
+		AlternateGroups       map[string]string // groups to post to instead of the groups the provider doesn't carry
+		FallbackGroups        []string          // groups to post to if the provider carries none of the groups of the article
 
-		pool         nntpPool.ConnectionPool
+		pool              nntpPool.ConnectionPool // access with getPool, as the pool is replaced upon a reconnect
+		poolLock          sync.RWMutex
+		offline           atomic.Bool
+		offlineErr        error
+		consecutiveErrors atomic.Uint32
+		poolErrors        atomic.Uint32 // consecutive errors getting a connection from the pool
+		group             *backbone
+		reach             int // number of other providers an upload propagates to (from the topology file)
+		// updated upon a reconnect while the segments are checked
 		capabilities struct {
-			ihave bool
-			post  bool
+			ihave atomic.Bool
+			post  atomic.Bool
 		}
 		articles struct {
 			checked   atomic.Uint64
 			available atomic.Uint64
 			missing   atomic.Uint64
 			refreshed atomic.Uint64
+
+			missingFingerprint atomic.Uint64 // sum of the hashes of the missing message IDs
+			offline            atomic.Bool   // provider was offline during the run
 		}
+		metrics providerMetrics
 	}
 
 	Config struct {
@@ -60,8 +80,9 @@ type (
 
 type (
 	segmentChanItem struct {
-		segment  nzbparser.NzbSegment
-		fileName string
+		segment     nzbparser.NzbSegment
+		fileName    string
+		fileSubject string
 	}
 	providerStatistic map[string]uint64
 	fileStatistic     struct {
@@ -80,12 +101,15 @@ var (
 	ihaveProviders []*Provider // Providers with IHAVE capability
 	postProviders  []*Provider // Providers with POST capability
 
-	err           error
-	maxConns      uint32
-	maxConnsLock  sync.Mutex
-	segmentChan   chan segmentChanItem
-	segmentChanWG sync.WaitGroup
-	sendArticleWG sync.WaitGroup
+	err             error
+	fatalExitCode   = 1  // exit code used by exit() upon errors
+	fatalPrefix     = "" // prefix of the fatal error output line
+	errRunCancelled = fmt.Errorf("run cancelled")
+	maxConns        uint32
+	maxConnsLock    sync.Mutex
+	segmentChan     chan segmentChanItem
+	segmentChanWG   sync.WaitGroup
+	sendArticleWG   sync.WaitGroup
 
 	preparationStartTime  time.Time
 	segmentCheckStartTime time.Time
@@ -93,7 +117,17 @@ var (
 	uploadBar             *cmpb.Bar
 	uploadBarStarted      bool
 	uploadBarMutex        sync.Mutex
-	progressBars          = cmpb.NewWithParam(&cmpb.Param{
+	runCtx                = context.Background() // context of the current run, cancelled to stop the run
+	runProgress           struct {               // progress of the current run as shown by the progress bars
+		segments        atomic.Uint64 // segments to check (the sampled segments in sampling mode)
+		segmentsChecked atomic.Uint64
+		uploadsTotal    atomic.Uint64
+		uploadsDone     atomic.Uint64
+		unrecoverable   atomic.Uint64 // segments missing on all providers
+		invalid         atomic.Uint64 // articles not re-uploaded as they don't match the NZB file
+	}
+	progressBars      *cmpb.Progress
+	progressBarsParam = cmpb.Param{
 		Interval:     200 * time.Microsecond,
 		Out:          color.Output,
 		ScrollUp:     cmpb.AnsiScrollUp,
@@ -110,40 +144,92 @@ var (
 		Empty:        '-',
 		Full:         '=',
 		Curr:         '>',
-	})
+	}
 	fileStat     = make(filesStatistic)
 	fileStatLock sync.Mutex
+	runLock      sync.Mutex
 )
 
-func init() {
+func main() {
 	parseArguments()
 	fmt.Println(args.Version())
 
-	if args.Debug {
-		logFileName := strings.TrimSuffix(filepath.Base(args.NZBFile), filepath.Ext(filepath.Base(args.NZBFile))) + ".log"
-		f, err := os.Create(logFileName)
-		if err != nil {
-			exit(fmt.Errorf("unable to open debug log file: %v", err))
+	switch command {
+	case "history":
+		showHistory()
+		return
+	case "schedule":
+		runSchedule()
+		return
+	case "serve":
+		runServer()
+		return
+	case "archive":
+		runArchive()
+		return
+	case "post-spool":
+		runPostSpool()
+		return
+	case "providers":
+		switch providersArgs.Action {
+		case "topology":
+			runProvidersTopology()
+		case "headers":
+			runProvidersHeaders()
+		default:
+			runProvidersTest()
 		}
-		log.SetOutput(f)
-	} else {
-		log.SetOutput(io.Discard)
+		return
+	case "postprocess":
+		runPostProcess()
+		return
 	}
 
+	setupLogging(args.NZBFile, args.Debug)
+
 	log.Print("preparing...")
 	preparationStartTime = time.Now()
-	// parse the argument
 
 	// load the NZB file
 	if nzbfile, err = loadNzbFile(args.NZBFile); err != nil {
 		exit(fmt.Errorf("unable to load NZB file '%s': %v'", args.NZBFile, err))
 	}
 
+	setupProviders(args.Provider)
+
+	log.Printf("preparation took %v", time.Since(preparationStartTime))
+
+	if err := checkNzbFile(context.Background(), args.NZBFile); err != nil {
+		exit(err)
+	}
+	closePools()
+}
+
+func setupLogging(path string, debug bool) {
+	if debug {
+		logFileName := strings.TrimSuffix(filepath.Base(path), filepath.Ext(filepath.Base(path))) + ".log"
+		f, err := os.Create(logFileName)
+		if err != nil {
+			exit(fmt.Errorf("unable to open debug log file: %v", err))
+		}
+		log.SetOutput(redactingWriter{out: f})
+	} else {
+		log.SetOutput(io.Discard)
+	}
+}
+
+// setupProviders loads the provider list, creates the connection pools and starts the segment workers.
+// It must only be called once, all subsequent runs share the pools and the workers.
+func setupProviders(path string) {
 	// load the provider list
-	if providerList, err = loadProviderList(args.Provider); err != nil {
+	if providerList, err = loadProviderList(path); err != nil {
 		exit(fmt.Errorf("unable to load provider list: %v", err))
 	}
+	startProviders()
+}
 
+// startProviders creates the connection pools for the already loaded provider list and starts the segment workers
+func startProviders() {
 	go func() {
 		for {
 			select {
@@ -159,30 +245,11 @@ func init() {
 
 	// setup the nntp connection pool for each provider
 	var providerWG sync.WaitGroup
+	// a failing provider is set offline, the runs continue with the remaining providers
 	for n := range providerList {
-		n := n
 		providerWG.Add(1)
 		go func(provider *Provider) {
 			defer providerWG.Done()
-			if pool, err := nntpPool.New(&nntpPool.Config{
-				Name:                  provider.Name,
-				Host:                  provider.Host,
-				Port:                  provider.Port,
-				SSL:                   provider.SSL,
-				SkipSSLCheck:          provider.SkipSslCheck,
-				User:                  provider.Username,
-				Pass:                  provider.Password,
-				MaxConns:              provider.MaxConns,
-				ConnWaitTime:          time.Duration(provider.ConnWaitTime) * time.Second,
-				IdleTimeout:           time.Duration(provider.IdleTimeout) * time.Second,
-				HealthCheck:           provider.HealthCheck,
-				MaxTooManyConnsErrors: provider.MaxTooManyConnsErrors,
-				MaxConnErrors:         provider.MaxConnErrors,
-			}, 0); err != nil {
-				exit(fmt.Errorf("unable to create the connection pool for provider '%s': %v", providerList[n].Name, err))
-			} else {
-				provider.pool = pool
-			}
 
 			// calculate the max connections
 			maxConnsLock.Lock()
@@ -191,24 +258,43 @@ func init() {
 			}
 			maxConnsLock.Unlock()
 
+			if pool, err := newProviderPool(provider); err != nil {
+				provider.setOffline(fmt.Errorf("unable to create the connection pool: %v", err))
+				return
+			} else {
+				provider.setPool(pool)
+			}
+
 			// check the ihave and post capabilities of the provider
-			if ihave, post, err := checkCapabilities(&providerList[n]); err != nil {
-				exit(fmt.Errorf("unable to check capabilities of provider '%s': %v", providerList[n].Name, err))
+			if ihave, post, err := checkCapabilities(provider, provider.getPool()); err != nil {
+				provider.setOffline(fmt.Errorf("unable to check capabilities: %v", err))
 			} else {
-				providerList[n].capabilities.ihave = ihave
-				providerList[n].capabilities.post = post
-				log.Printf("capabilities of '%s': IHAVE: %v | POST: %v", providerList[n].Name, ihave, post)
+				provider.capabilities.ihave.Store(ihave)
+				provider.capabilities.post.Store(post)
+				log.Printf("capabilities of '%s': IHAVE: %v | POST: %v", provider.Name, ihave, post)
 			}
 		}(&providerList[n])
 	}
 	providerWG.Wait()
 
+	online := 0
+	for n := range providerList {
+		if providerList[n].isOffline() {
+			fmt.Printf("Provider '%s' is offline: %v\n", providerList[n].Name, providerList[n].offlineError())
+		} else {
+			online++
+		}
+	}
+	if online == 0 {
+		exit(fmt.Errorf("all providers are offline"))
+	}
+
 	// check if we have at least one provider with IHAVE or POST capability
 	for n := range providerList {
-		if providerList[n].capabilities.ihave {
+		if providerList[n].capabilities.ihave.Load() {
 			ihaveProviders = append(ihaveProviders, &providerList[n])
 		}
-		if providerList[n].capabilities.post {
+		if providerList[n].capabilities.post.Load() {
 			postProviders = append(postProviders, &providerList[n])
 		}
 	}
@@ -216,6 +302,9 @@ func init() {
 		log.Print("no provider has IHAVE or POST capability")
 	}
 
+	setupBackbones()
+	loadTopology(args.Topology)
+
 	// make the channels
 	segmentChan = make(chan segmentChanItem, 8*maxConns)
 
@@ -223,22 +312,77 @@ func init() {
 	for i := uint32(0); i < 4*maxConns; i++ {
 		go processSegment()
 	}
-
-	log.Printf("preparation took %v", time.Since(preparationStartTime))
 }
 
-func main() {
+// checkNzbFile runs the segment check (and re-upload) of the already loaded nzbfile.
+// Runs are serialised, as the statistics and progress bars are shared global state.
+// If ctx is cancelled, no further segments are checked and errRunCancelled is returned.
+// If the NZB file is beyond saving, the run is aborted early and errNzbDead is returned.
+func checkNzbFile(ctx context.Context, path string) error {
+	runLock.Lock()
+	defer runLock.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	runCtx = ctx
 
-	startString := fmt.Sprintf("starting segment check of %v segments", nzbfile.TotalSegments)
+	// reset the statistics of the previous run
+	for n := range providerList {
+		providerList[n].articles.checked.Store(0)
+		providerList[n].articles.available.Store(0)
+		providerList[n].articles.missing.Store(0)
+		providerList[n].articles.refreshed.Store(0)
+		providerList[n].articles.missingFingerprint.Store(0)
+		providerList[n].articles.offline.Store(providerList[n].isOffline())
+	}
+	fileStatLock.Lock()
+	fileStat = make(filesStatistic)
+	fileStatLock.Unlock()
+	resetNewMessageIDs()
+	resetPostedGroups()
+	resetPlan(path)
+	progressBars = cmpb.NewWithParam(&progressBarsParam)
+	uploadBarStarted = false
+	runProgress.segmentsChecked.Store(0)
+	runProgress.uploadsTotal.Store(0)
+	runProgress.uploadsDone.Store(0)
+	runProgress.unrecoverable.Store(0)
+	runProgress.invalid.Store(0)
+	exported.Store(0)
+	reseeded.Store(0)
+	resetRegenerated()
+	resetPar2Candidates()
+
+	// segments to check of each file (a sample of the segments in sampling mode)
+	segments := make([][]nzbparser.NzbSegment, len(nzbfile.Files))
+	totalSegments := 0
+	for n, file := range nzbfile.Files {
+		segments[n] = segmentsToCheck(file)
+		totalSegments += len(segments[n])
+	}
+
+	runProgress.segments.Store(uint64(totalSegments))
+	setupEarlyAbort(totalSegments, cancel)
+
+	startString := fmt.Sprintf("starting segment check of %v segments", totalSegments)
+	if sampling != nil {
+		startString = fmt.Sprintf("starting segment check of a sample of %v of %v segments", totalSegments, nzbfile.Segments)
+	}
 	if args.CheckOnly {
 		startString = startString + " (check only, no re-upload)"
+	} else if args.DryRun {
+		startString = startString + " (dry run, no re-upload)"
+	} else if args.Export != "" {
+		startString = startString + fmt.Sprintf(" (export to '%s', no re-upload)", args.Export)
 	}
 	fmt.Println(strings.ToUpper(startString[:1]) + startString[1:])
 	log.Print(startString)
+	if !args.CheckOnly && args.Export == "" {
+		verifyNzbGroups()
+	}
 	segmentCheckStartTime = time.Now()
 
 	// segment check progressbar
-	segmentBar = progressBars.NewBar("Checking segments", nzbfile.TotalSegments)
+	segmentBar = progressBars.NewBar("Checking segments", totalSegments)
 	segmentBar.SetPreBar(cmpb.CalcSteps)
 	segmentBar.SetPostBar(cmpb.CalcTime)
 
@@ -246,26 +390,55 @@ func main() {
 	progressBars.Start()
 
 	// loop through all file tags within the NZB file
-	for _, file := range nzbfile.Files {
+files:
+	for n, file := range nzbfile.Files {
 		fileStatLock.Lock()
 		fileStat[file.Filename] = new(fileStatistic)
 		fileStat[file.Filename].available = make(providerStatistic)
 		fileStat[file.Filename].totalSegments = uint64(file.TotalSegments)
+		if sampling != nil {
+			// the statistic is relative to the sampled segments
+			fileStat[file.Filename].totalSegments = uint64(len(segments[n]))
+		}
 		fileStatLock.Unlock()
 		// loop through all segment tags within each file tag
-		for _, segment := range file.Segments {
+		for _, segment := range segments[n] {
 			segmentChanWG.Add(1)
-			segmentChan <- segmentChanItem{segment, file.Filename}
+			select {
+			case segmentChan <- segmentChanItem{segment, file.Filename, file.Subject}:
+			case <-ctx.Done():
+				segmentChanWG.Done()
+				break files
+			}
 		}
 	}
 	segmentChanWG.Wait()
-	segmentBar.SetMessage("done")
 	sendArticleWG.Wait()
-	if uploadBarStarted {
-		uploadBar.SetMessage("done")
+	aborted := earlyAbort.aborted.Load()
+	if !aborted && ctx.Err() == nil {
+		repairWithPar2(ctx)
+	}
+	closeArchive()
+	if ctx.Err() != nil && !aborted {
+		uploadBarMutex.Lock()
+		progressBars.Stop("cancelled", "")
+		uploadBarMutex.Unlock()
+		progressBars.Wait()
+		log.Print("run cancelled")
+		return errRunCancelled
+	}
+	if aborted {
+		uploadBarMutex.Lock()
+		progressBars.Stop("aborted", "")
+		uploadBarMutex.Unlock()
+	} else {
+		segmentBar.SetMessage("done")
+		if uploadBarStarted {
+			uploadBar.SetMessage("done")
+		}
 	}
 	progressBars.Wait()
-	log.Printf("segment check took %v | %v ms/segment", time.Since(segmentCheckStartTime), float32(time.Since(segmentCheckStartTime).Milliseconds())/float32(nzbfile.Segments))
+	log.Printf("segment check took %v | %v ms/segment", time.Since(segmentCheckStartTime), float32(time.Since(segmentCheckStartTime).Milliseconds())/float32(totalSegments))
 	for n := range providerList {
 		result := fmt.Sprintf("Results for '%s': checked: %v | available: %v | missing: %v | refreshed: %v | %v connections used",
 			providerList[n].Name,
@@ -273,18 +446,78 @@ func main() {
 			providerList[n].articles.available.Load(),
 			providerList[n].articles.missing.Load(),
 			providerList[n].articles.refreshed.Load(),
-			providerList[n].pool.MaxConns(),
+			providerList[n].connectionsUsed(),
 		)
+		if providerList[n].articles.offline.Load() {
+			result += fmt.Sprintf(" | offline: %v", providerList[n].offlineError())
+		}
 		fmt.Println(result)
 		log.Print(result)
 	}
-	for n := range providerList {
-		go providerList[n].pool.Close()
+	for _, result := range backboneResults() {
+		fmt.Println(result)
+		log.Print(result)
+	}
+	for _, result := range postedGroupsResults() {
+		fmt.Println(result)
+		log.Print(result)
+	}
+	for _, suggestion := range backboneSuggestions() {
+		fmt.Println(suggestion)
+		log.Print(suggestion)
+	}
+	if invalid := runProgress.invalid.Load(); invalid > 0 {
+		result := fmt.Sprintf("%v articles were not re-uploaded as they don't match the NZB file (see the debug log)", invalid)
+		fmt.Println(result)
+		log.Print(result)
+	}
+	if args.Export != "" {
+		fmt.Println(exportResult())
+		log.Print(exportResult())
+	}
+	// in a dry run, the articles which would be re-seeded, regenerated or reconstructed are in the plan
+	if args.Archive != "" && args.Export == "" && !args.DryRun {
+		result := fmt.Sprintf("%v articles missing on all providers were re-seeded from the archive '%s'", reseeded.Load(), args.Archive)
+		fmt.Println(result)
+		log.Print(result)
+	}
+	if args.SourceDir != "" && args.Export == "" && !args.DryRun {
+		result := fmt.Sprintf("%v articles missing on all providers were regenerated from the local files in '%s'", regenerated.Load(), args.SourceDir)
+		fmt.Println(result)
+		log.Print(result)
+	}
+	if args.Par2 && args.Export == "" && !args.DryRun {
+		result := fmt.Sprintf("%v articles missing on all providers were reconstructed with the par2 files", reconstructed.Load())
+		fmt.Println(result)
+		log.Print(result)
+	}
+	logSampleResults()
+	if aborted {
+		fmt.Println(abortResult())
+		log.Print(abortResult())
 	}
-	runtime := fmt.Sprintf("Total runtime %v | %v ms/segment", time.Since(preparationStartTime), float32(time.Since(preparationStartTime).Milliseconds())/float32(nzbfile.Segments))
+	runtime := fmt.Sprintf("Total runtime %v | %v ms/segment", time.Since(preparationStartTime), float32(time.Since(preparationStartTime).Milliseconds())/float32(totalSegments))
 	fmt.Println(runtime)
 	log.Print(runtime)
-	writeCsvFile()
+	if err := writeCsvFile(path); err != nil {
+		return err
+	}
+	if args.DryRun {
+		// nothing was re-uploaded, so the run is neither recorded nor notified
+		if err := writePlanFile(path); err != nil {
+			return err
+		}
+	} else {
+		if err := writeRepostNzbFile(path); err != nil {
+			return err
+		}
+		writeHistory(path)
+		notifyRunResults(path)
+	}
+	if aborted {
+		return errNzbDead
+	}
+	return nil
 }
 
 func loadNzbFile(path string) (*nzbparser.Nzb, error) {
@@ -292,7 +525,14 @@ func loadNzbFile(path string) (*nzbparser.Nzb, error) {
 		return nil, err
 	} else {
 		defer b.Close()
-		if nzbfile, err := nzbparser.Parse(b); err != nil {
+		var r io.Reader = b
+		// gzipped NZB files (e.g. as provided by SABnzbd)
+		if strings.EqualFold(filepath.Ext(path), ".gz") {
+			if r, err = gzip.NewReader(b); err != nil {
+				return nil, err
+			}
+		}
+		if nzbfile, err := nzbparser.Parse(r); err != nil {
 			return nil, err
 		} else {
 			return nzbfile, nil
@@ -304,51 +544,85 @@ func loadProviderList(path string) ([]Provider, error) {
 	if file, err := os.ReadFile(path); err != nil {
 		return nil, err
 	} else {
-		cfg := Config{}
-		if err := json.Unmarshal(file, &cfg.providers); err != nil {
+		if file, err = configToJSON(path, file); err != nil {
 			return nil, err
 		}
+		cfg := Config{}
+		if isProviderArray(file) {
+			if err := json.Unmarshal(file, &cfg.providers); err != nil {
+				return nil, err
+			}
+		} else {
+			// unified config file with a providers section
+			config := configFile{}
+			if err := json.Unmarshal(file, &config); err != nil {
+				return nil, err
+			}
+			cfg.providers = config.Providers
+			// the header rules of the providers take precedence over the common ones
+			for n := range cfg.providers {
+				cfg.providers[n].Headers = append(cfg.providers[n].Headers, config.Headers...)
+			}
+		}
+		if len(cfg.providers) == 0 {
+			return nil, fmt.Errorf("no providers configured")
+		}
+		for n := range cfg.providers {
+			if err := resolveCredentials(&cfg.providers[n]); err != nil {
+				return nil, fmt.Errorf("provider '%s': %v", cfg.providers[n].Name, err)
+			}
+			if err := compileHeaderRules(&cfg.providers[n]); err != nil {
+				return nil, fmt.Errorf("provider '%s': %v", cfg.providers[n].Name, err)
+			}
+		}
 		return cfg.providers, nil
 	}
 }
 
-func checkCapabilities(provider *Provider) (bool, bool, error) {
-	if conn, err := provider.pool.Get(context.TODO()); err != nil {
+func checkCapabilities(provider *Provider, pool nntpPool.ConnectionPool) (bool, bool, error) {
+	if conn, err := pool.Get(context.TODO()); err != nil {
+		provider.metrics.connectionError(err)
 		return false, false, err
 	} else {
-		defer provider.pool.Put(conn)
-		var ihave, post bool
-		if capabilities, err := conn.Capabilities(); err == nil {
-			for _, capability := range capabilities {
-				if strings.ToLower(capability) == "ihave" {
-					ihave = true
-				}
-				if strings.ToLower(capability) == "post" {
-					post = true
-				}
+		defer pool.Put(conn)
+		ihave, post := connCapabilities(conn.Conn)
+		return ihave, post, nil
+	}
+}
+
+// connCapabilities returns the ihave and post capabilities of the nntp server
+func connCapabilities(conn *nntp.Conn) (bool, bool) {
+	var ihave, post bool
+	if capabilities, err := conn.Capabilities(); err == nil {
+		for _, capability := range capabilities {
+			if strings.ToLower(capability) == "ihave" {
+				ihave = true
 			}
-		} else {
-			// nntp server is not RFC 3977 compliant
-			// check post capability
-			article := new(nntp.Article)
-			if err := conn.Post(article); err != nil {
-				if err.Error()[0:3] != "440" {
-					post = true
-				}
-			} else {
+			if strings.ToLower(capability) == "post" {
 				post = true
 			}
-			// check ihave capability
-			if err := conn.IHave(article); err != nil {
-				if err.Error()[0:3] != "500" {
-					ihave = true
-				}
-			} else {
+		}
+	} else {
+		// nntp server is not RFC 3977 compliant
+		// check post capability
+		article := new(nntp.Article)
+		if err := conn.Post(article); err != nil {
+			if err.Error()[0:3] != "440" {
+				post = true
+			}
+		} else {
+			post = true
+		}
+		// check ihave capability
+		if err := conn.IHave(article); err != nil {
+			if err.Error()[0:3] != "500" {
 				ihave = true
 			}
+		} else {
+			ihave = true
 		}
-		return ihave, post, nil
 	}
+	return ihave, post
 }
 
 func processSegment() {
@@ -356,10 +630,19 @@ func processSegment() {
 		segment := segmentChanItem.segment
 		fileName := segmentChanItem.fileName
 		func() {
+			skipped := false
 			defer func() {
 				segmentChanWG.Done()
 				segmentBar.Increment()
+				if !skipped {
+					runProgress.segmentsChecked.Add(1)
+				}
 			}()
+			// skip the remaining segments of a cancelled or aborted run
+			if runCtx.Err() != nil {
+				skipped = true
+				return
+			}
 			// positiv provider list (providers who have the article)
 			var availableOn []*Provider
 			var availableOnLock sync.Mutex
@@ -368,96 +651,174 @@ func processSegment() {
 			var missingOnLock sync.Mutex
 			// segment check waitgroup
 			var segmentCheckWG sync.WaitGroup
-			// loop through each provider in the provider list
-			for n := range providerList {
-				n := n
+			// backbones which could not check the article (e.g. because all their providers are offline)
+			var undetermined atomic.Bool
+			// loop through each backbone, providers of the same backbone share their articles
+			for _, group := range backbones {
+				group := group
 				segmentCheckWG.Add(1)
 				go func() {
 					defer segmentCheckWG.Done()
-					// check if message is available on the provider
-					if isAvailable, err := checkMessageID(&providerList[n], segment.Id); err != nil {
-						// error handling
-						log.Print(fmt.Errorf("unable to check article <%s> on provider '%s': %v", segment.Id, providerList[n].Name, err))
-						// TODO: What do we do with such errors??
-					} else {
-						providerList[n].articles.checked.Add(1)
-						if isAvailable {
-							providerList[n].articles.available.Add(1)
-							fileStatLock.Lock()
-							fileStat[fileName].available[providerList[n].Name]++
-							fileStatLock.Unlock()
-							// if yes add the provider to the positiv list
-							availableOnLock.Lock()
-							availableOn = append(availableOn, &providerList[n])
-							availableOnLock.Unlock()
+					// check the article on the first provider of the backbone, the next one is only asked if the check fails
+					for _, provider := range group.providers {
+						if provider.isOffline() {
+							continue
+						}
+						// check if message is available on the provider
+						if isAvailable, err := checkMessageID(provider, segment.Id); err != nil {
+							// error handling
+							log.Print(fmt.Errorf("unable to check article <%s> on provider '%s': %v", segment.Id, provider.Name, err))
+							// TODO: What do we do with such errors??
+							continue
 						} else {
-							providerList[n].articles.missing.Add(1)
-							// if yes add the provider to the positiv list
-							missingOnLock.Lock()
-							missingOn = append(missingOn, &providerList[n])
-							missingOnLock.Unlock()
+							provider.metrics.checked.Add(1)
+							provider.addCheckResult(segment.Id, fileName, isAvailable)
+							if isAvailable {
+								provider.metrics.available.Add(1)
+								// if yes add the provider to the positiv list
+								availableOnLock.Lock()
+								availableOn = append(availableOn, provider)
+								availableOnLock.Unlock()
+							} else {
+								provider.metrics.missing.Add(1)
+								// if yes add the provider to the positiv list
+								missingOnLock.Lock()
+								missingOn = append(missingOn, provider)
+								missingOnLock.Unlock()
+							}
+							return
 						}
 					}
+					undetermined.Store(true)
 				}()
 			}
 			segmentCheckWG.Wait()
+			// an article is only unrecoverable if it is known to be missing on all the backbones
+			if len(availableOn) == 0 && len(missingOn) > 0 && !undetermined.Load() {
+				runProgress.unrecoverable.Add(1)
+				unrecoverableSegment(segment.Bytes, fileName)
+			}
 			// if negativ list contains entries at least one provider is missing the article
 			if !args.CheckOnly && len(missingOn) > 0 {
 				log.Printf("article <%s> is missing on at least one provider", segment.Id)
 				// check if positiv list contains entries
 				// without at least on provider having the article we cannot fix the others
 				if len(availableOn) > 0 {
-					uploadBarMutex.Lock()
-					if uploadBarStarted {
-						uploadBar.IncrementTotal()
-					} else {
-						uploadBar = progressBars.NewBar("Uploading articles", 1)
-						uploadBar.SetPreBar(cmpb.CalcSteps)
-						uploadBar.SetPostBar(cmpb.CalcTime)
-						uploadBarStarted = true
+					if !addUpload() {
+						return
 					}
-					uploadBarMutex.Unlock()
+					planAddSegment(segment, fileName, missingOn)
 					// load article
-					if article, err := loadArticle(availableOn, segment.Id); err != nil {
+					if article, err := loadArticle(backboneMembers(availableOn), segment, segmentChanItem.fileSubject); err != nil {
 						log.Print(err)
+						planSetError(segment.Id, err)
+						if errors.Is(err, errInvalidArticle) {
+							runProgress.invalid.Add(1)
+						}
 						uploadBar.Increment()
+						runProgress.uploadsDone.Add(1)
 					} else {
 						// reupload article
 						sendArticleWG.Add(1)
 						go func() {
+							defer func() {
+								uploadBar.Increment()
+								runProgress.uploadsDone.Add(1)
+								sendArticleWG.Done()
+							}()
+							// skip the pending uploads of a cancelled or aborted run
+							if runCtx.Err() != nil {
+								return
+							}
+							if args.Export != "" {
+								if err := exportArticle(article, segment, fileName, missingOn); err != nil {
+									log.Print(err)
+								}
+								return
+							}
+							if args.NewMessageIDs {
+								if err := repostArticle(article, segment.Id); err != nil {
+									log.Print(err)
+								}
+								return
+							}
 							// reupload article
-							if err := reuploadArticle(missingOn, article, segment.Id); err != nil {
+							failed := false
+							for _, targets := range uploadTargets(missingOn) {
+								if _, err := reuploadArticle(targets, article, segment.Id); err != nil {
+									failed = true
+								}
+							}
+							if failed {
 								// on error, try re-uploading on one of the providers having the article
-								if err := reuploadArticle(availableOn, article, segment.Id); err != nil {
+								if _, err := reuploadArticle(backboneMembers(availableOn), article, segment.Id); err != nil {
 									log.Print(err)
 								}
 							}
-							uploadBar.Increment()
-							sendArticleWG.Done()
 						}()
 					}
+				} else if undetermined.Load() {
+					err := fmt.Errorf("article <%s> is missing on all providers which could check it", segment.Id)
+					log.Print(err)
+					planAddSegment(segment, fileName, missingOn)
+					planSetError(segment.Id, err)
 				} else {
 					// error handling if article is missing on all providers
-					log.Print(fmt.Errorf("article <%s> is missing on all providers", segment.Id))
+					err := fmt.Errorf("article <%s> is missing on all providers", segment.Id)
+					log.Print(err)
+					planAddSegment(segment, fileName, missingOn)
+					if !reseedArticle(segment, missingOn) && !regenerateArticle(segment, fileName, missingOn) && !addPar2Candidate(segment, fileName, missingOn) {
+						planSetError(segment.Id, err)
+					}
 				}
 			}
 		}()
 	}
 }
 
+// addUpload adds an upload to the upload progressbar, it returns false if the run is cancelled
+func addUpload() bool {
+	uploadBarMutex.Lock()
+	defer uploadBarMutex.Unlock()
+	if runCtx.Err() != nil {
+		return false
+	}
+	runProgress.uploadsTotal.Add(1)
+	if uploadBarStarted {
+		uploadBar.IncrementTotal()
+	} else {
+		uploadBarName := "Uploading articles"
+		if args.Export != "" {
+			uploadBarName = "Exporting articles"
+		}
+		uploadBar = progressBars.NewBar(uploadBarName, 1)
+		uploadBar.SetPreBar(cmpb.CalcSteps)
+		uploadBar.SetPostBar(cmpb.CalcTime)
+		uploadBarStarted = true
+	}
+	return true
+}
+
 func checkMessageID(provider *Provider, messageID string) (bool, error) {
-	if conn, err := provider.pool.Get(context.TODO()); err != nil {
+	if conn, pool, err := provider.getConn(); err != nil {
 		return false, err
 	} else {
-		defer provider.pool.Put(conn)
+		defer pool.Put(conn)
+		start := time.Now()
 		if _, _, err := conn.Stat("<" + messageID + ">"); err == nil {
+			provider.metrics.observe("stat", start, nil)
+			provider.commandResult(nil)
 			// if article is availabel return true
 			return true, nil
 		} else {
 			if err.Error()[0:3] == "430" {
+				provider.metrics.observe("stat", start, nil)
 				// upon error "430 No Such Article" return false
+				provider.commandResult(nil)
 				return false, nil
 			} else {
+				provider.metrics.observe("stat", start, err)
+				provider.commandResult(err)
 				// upon any other error return error
 				return false, err
 			}
@@ -465,104 +826,129 @@ func checkMessageID(provider *Provider, messageID string) (bool, error) {
 	}
 }
 
-func loadArticle(providerList []*Provider, messageID string) (*nntp.Article, error) {
+func loadArticle(providerList []*Provider, segment nzbparser.NzbSegment, fileSubject string) (*nntp.Article, error) {
+	messageID := segment.Id
+	invalid := false
 	for _, provider := range providerList {
+		if provider.isOffline() {
+			continue
+		}
 		// try to load the article from the provider
 		log.Printf("loading article <%s> from provider '%s'", messageID, provider.Name)
 		if article, err := getArticleFromProvider(provider, messageID); err != nil {
 			// if the article cannot be loaded continue with the next provider on the list
 			log.Print(fmt.Errorf("unable to load article <%s> from provider '%s': %v", messageID, provider.Name, err))
 			continue
+		} else if err := validateArticle(article, segment, fileSubject); err != nil {
+			// never re-upload a wrong article, but another provider might have the right one
+			log.Print(fmt.Errorf("article <%s> of provider '%s' doesn't match the NZB file: %v", messageID, provider.Name, err))
+			invalid = true
+			continue
 		} else {
+			planSetSource(messageID, provider.Name)
 			return article, err
 		}
 	}
+	if invalid {
+		return nil, fmt.Errorf("article <%s> is %w", messageID, errInvalidArticle)
+	}
 	return nil, fmt.Errorf("unable to load article <%s> from any provider", messageID)
 }
 
 func getArticleFromProvider(provider *Provider, messageID string) (*nntp.Article, error) {
-	if conn, err := provider.pool.Get(context.TODO()); err != nil {
+	if conn, pool, err := provider.getConn(); err != nil {
 		return nil, err
 	} else {
-		defer provider.pool.Put(conn)
+		defer pool.Put(conn)
+		start := time.Now()
 		if article, err := conn.Article("<" + messageID + ">"); err != nil {
+			provider.metrics.observe("article", start, err)
+			provider.commandResult(err)
 			return nil, err
 		} else {
-			return copyArticle(article, []byte{})
+			article, err := copyArticle(article, []byte{})
+			provider.metrics.observe("article", start, err)
+			return article, err
 		}
 	}
 }
 
-func reuploadArticle(providerList []*Provider, article *nntp.Article, segmentID string) error {
+// reuploadArticle uploads the article to the first of the providers accepting it and returns this provider
+func reuploadArticle(providerList []*Provider, article *nntp.Article, segmentID string) (*Provider, error) {
 	var body []byte
 	body, err := io.ReadAll(article.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	article.Body = bytes.NewReader(body)
-	for n, provider := range providerList {
-		if provider.capabilities.post {
+	// upload first where the propagation is known to reach the most providers
+	for n, provider := range sortByReach(providerList) {
+		if provider.canUpload() && !provider.isOffline() {
 			if copiedArticle, err := copyArticle(article, body); err != nil {
-				return err
+				return nil, err
 			} else {
 				// send the article to the provider
 				log.Printf("re-uploading article <%s> to provider '%s' (%v. attempt)", segmentID, provider.Name, n+1)
 				if err := postArticleToProvider(provider, copiedArticle); err != nil {
 					// error handling if re-uploading the article was unsuccessfull
 					log.Print(fmt.Errorf("error re-uploading article <%s> to provider '%s': %v", segmentID, provider.Name, err))
+				} else if args.DryRun {
+					planAddUpload(segmentID, provider, copiedArticle.Header, len(body))
+					log.Printf("article <%s> would be sent to provider '%s' (dry run)", segmentID, provider.Name)
+					return provider, nil
 				} else {
 					provider.articles.refreshed.Add(1)
+					provider.metrics.refreshed.Add(1)
+					provider.metrics.uploadBytes.Add(uint64(len(body)))
 					// handling of successfull send
-					log.Printf("article <%s> successfully sent to provider '%s'", segmentID, provider.Name)
+					log.Printf("article <%s> successfully sent to provider '%s' (groups: %s)", segmentID, provider.Name, firstValue(copiedArticle.Header["Newsgroups"]))
 					// if post was successfull return
 					// other providers missing this article will get it from this provider
-					return nil
+					return provider, nil
 				}
 			}
 		}
 	}
-	return fmt.Errorf("unable to re-upload article <%s> to any provider", segmentID)
+	return nil, fmt.Errorf("unable to re-upload article <%s> to any provider", segmentID)
 }
 
 func postArticleToProvider(provider *Provider, article *nntp.Article) error {
-	if conn, err := provider.pool.Get(context.TODO()); err != nil {
+	// for post, first rewrite the headers
+	if err := rewriteHeaders(article, provider); err != nil {
 		return err
-	} else {
-		defer provider.pool.Put(conn)
-		// for post, first clean the headers
-		cleanHeaders(article)
-		// post the article
-		if err := conn.Post(article); err != nil {
-			return err
-		} else {
-			return nil
-		}
 	}
-}
-
-func cleanHeaders(article *nntp.Article) {
-	// minimum headers required for post
-	headers := []string{
-		"From",
-		"Subject",
-		"Newsgroups",
-		"Message-Id",
-		"Date",
-		"Path",
+	if err := setPostGroups(provider, article.Header); err != nil {
+		return err
 	}
-	for header := range article.Header {
-		if slices.Contains(headers, header) {
-			// clean Path header
-			if header == "Path" {
-				article.Header[header] = []string{"not-for-mail"}
-			}
-			// update Date header to now
-			if header == "Date" {
-				article.Header[header] = []string{time.Now().Format(time.RFC1123Z)}
-			}
-		} else {
-			delete(article.Header, header)
+	// the dry run stops right before the post
+	if args.DryRun {
+		return nil
+	}
+	if useIHave && provider.capabilities.ihave.Load() {
+		// offer the article with IHAVE
+		log.Printf("offering article %s to provider '%s' with IHAVE", firstValue(article.Header["Message-Id"]), provider.Name)
+		start := time.Now()
+		err := ihaveArticle(provider, article)
+		provider.metrics.observe("ihave", start, err)
+		provider.commandResult(err)
+		if err == nil {
+			countPostedGroups(provider, article.Header)
 		}
+		return err
+	}
+	if conn, pool, err := provider.getConn(); err != nil {
+		return err
+	} else {
+		defer pool.Put(conn)
+		// post the article
+		start := time.Now()
+		err := conn.Post(article)
+		provider.metrics.observe("post", start, err)
+		provider.commandResult(err)
+		if err == nil {
+			countPostedGroups(provider, article.Header)
+		}
+		return err
 	}
 }
 
@@ -584,13 +970,14 @@ func copyArticle(article *nntp.Article, body []byte) (*nntp.Article, error) {
 	return &newArticle, nil
 }
 
-func writeCsvFile() {
+func writeCsvFile(path string) error {
 	if args.Csv {
-		csvFileName := strings.TrimSuffix(filepath.Base(args.NZBFile), filepath.Ext(filepath.Base(args.NZBFile))) + ".csv"
+		csvFileName := strings.TrimSuffix(filepath.Base(path), filepath.Ext(filepath.Base(path))) + ".csv"
 		f, err := os.Create(csvFileName)
 		if err != nil {
-			exit(fmt.Errorf("unable to open csv file: %v", err))
+			return fmt.Errorf("unable to open csv file: %v", err)
 		}
+		defer f.Close()
 		log.Println("writing csv file...")
 		fmt.Print("Writing csv file... ")
 		csvWriter := csv.NewWriter(f)
@@ -609,9 +996,14 @@ func writeCsvFile() {
 				line[1] = "Total segments"
 				for n, providerName := range providers {
 					line[n+2] = providerName
+					for i := range providerList {
+						if providerList[i].Name == providerName && providerList[i].articles.offline.Load() {
+							line[n+2] += " (offline)"
+						}
+					}
 				}
 				if err := csvWriter.Write(line); err != nil {
-					exit(fmt.Errorf("unable to write to the csv file: %v", err))
+					return fmt.Errorf("unable to write to the csv file: %v", err)
 				}
 				firstLine = false
 			}
@@ -627,22 +1019,23 @@ func writeCsvFile() {
 				}
 			}
 			if err := csvWriter.Write(line); err != nil {
-				exit(fmt.Errorf("unable to write to the csv file: %v", err))
+				return fmt.Errorf("unable to write to the csv file: %v", err)
 			}
 		}
 		csvWriter.Flush()
 		if err := csvWriter.Error(); err != nil {
-			exit(fmt.Errorf("unable to write to the csv file: %v", err))
+			return fmt.Errorf("unable to write to the csv file: %v", err)
 		}
-		f.Close()
-		fmt.Print("done")
+		fmt.Println("done")
 	}
+	return nil
 }
 
 func exit(err error) {
 	if err != nil {
-		fmt.Printf("Fatal error: %v\n", err)
-		log.Fatal(err)
+		fmt.Printf("%sFatal error: %v\n", fatalPrefix, err)
+		log.Print(err)
+		os.Exit(fatalExitCode)
 	} else {
 		os.Exit(0)
 	}
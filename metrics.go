@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Tensai75/nntp"
+)
+
+type (
+	// cumulative metrics of a provider over all runs of the process
+	providerMetrics struct {
+		checked     atomic.Uint64
+		available   atomic.Uint64
+		missing     atomic.Uint64
+		refreshed   atomic.Uint64
+		uploadBytes atomic.Uint64
+
+		lock    sync.Mutex
+		errors  map[string]uint64     // errors by class
+		latency map[string]*histogram // command latencies by command
+	}
+
+	histogram struct {
+		counts []uint64 // one count per bucket in latencyBuckets plus +Inf
+		sum    float64
+		count  uint64
+	}
+)
+
+// upper bounds of the latency histogram buckets in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// observe records the latency of a command and, if it failed, the class of the error
+func (m *providerMetrics) observe(command string, start time.Time, err error) {
+	seconds := time.Since(start).Seconds()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.latency == nil {
+		m.latency = make(map[string]*histogram)
+	}
+	h, ok := m.latency[command]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		m.latency[command] = h
+	}
+	bucket := sort.SearchFloat64s(latencyBuckets, seconds)
+	h.counts[bucket]++
+	h.sum += seconds
+	h.count++
+	if err != nil {
+		m.countError(err)
+	}
+}
+
+// countError records the class of an error, the caller must hold the lock
+func (m *providerMetrics) countError(err error) {
+	if m.errors == nil {
+		m.errors = make(map[string]uint64)
+	}
+	m.errors[errorClass(err)]++
+}
+
+// connectionError records an error getting a connection from the pool
+func (m *providerMetrics) connectionError(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.countError(err)
+}
+
+// errorClass returns the class of an error for the metrics:
+// the response code for NNTP errors, otherwise the kind of the network error
+func errorClass(err error) string {
+	var nntpError nntp.Error
+	var netError net.Error
+	switch {
+	case errors.As(err, &nntpError):
+		return fmt.Sprintf("%03d", nntpError.Code)
+	case errors.As(err, &netError) && netError.Timeout():
+		return "timeout"
+	case errors.As(err, &netError):
+		return "network"
+	default:
+		return "other"
+	}
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+
+	writeMetricHeader(&b, "nzbrefresh_articles_checked_total", "counter", "Number of articles checked per provider.")
+	for n := range providerList {
+		writeMetric(&b, "nzbrefresh_articles_checked_total", providerLabel(&providerList[n]), providerList[n].metrics.checked.Load())
+	}
+	writeMetricHeader(&b, "nzbrefresh_articles_available_total", "counter", "Number of articles found available per provider.")
+	for n := range providerList {
+		writeMetric(&b, "nzbrefresh_articles_available_total", providerLabel(&providerList[n]), providerList[n].metrics.available.Load())
+	}
+	writeMetricHeader(&b, "nzbrefresh_articles_missing_total", "counter", "Number of articles found missing per provider.")
+	for n := range providerList {
+		writeMetric(&b, "nzbrefresh_articles_missing_total", providerLabel(&providerList[n]), providerList[n].metrics.missing.Load())
+	}
+	writeMetricHeader(&b, "nzbrefresh_articles_refreshed_total", "counter", "Number of articles re-uploaded per provider.")
+	for n := range providerList {
+		writeMetric(&b, "nzbrefresh_articles_refreshed_total", providerLabel(&providerList[n]), providerList[n].metrics.refreshed.Load())
+	}
+	writeMetricHeader(&b, "nzbrefresh_upload_bytes_total", "counter", "Number of bytes re-uploaded per provider.")
+	for n := range providerList {
+		writeMetric(&b, "nzbrefresh_upload_bytes_total", providerLabel(&providerList[n]), providerList[n].metrics.uploadBytes.Load())
+	}
+
+	writeMetricHeader(&b, "nzbrefresh_errors_total", "counter", "Number of failed commands per provider and error class.")
+	for n := range providerList {
+		m := &providerList[n].metrics
+		m.lock.Lock()
+		for _, class := range sortedKeys(m.errors) {
+			writeMetric(&b, "nzbrefresh_errors_total", fmt.Sprintf(`%s,class="%s"`, providerLabel(&providerList[n]), class), m.errors[class])
+		}
+		m.lock.Unlock()
+	}
+
+	writeMetricHeader(&b, "nzbrefresh_connections_max", "gauge", "Maximum number of simultaneously used connections per provider.")
+	for n := range providerList {
+		writeMetric(&b, "nzbrefresh_connections_max", providerLabel(&providerList[n]), providerList[n].connectionsUsed())
+	}
+	writeMetricHeader(&b, "nzbrefresh_connections_open", "gauge", "Number of currently open connections per provider.")
+	for n := range providerList {
+		if pool := providerList[n].getPool(); pool != nil {
+			_, open := pool.Conns()
+			writeMetric(&b, "nzbrefresh_connections_open", providerLabel(&providerList[n]), open)
+		}
+	}
+	writeMetricHeader(&b, "nzbrefresh_connections_used", "gauge", "Number of currently used connections per provider.")
+	for n := range providerList {
+		if pool := providerList[n].getPool(); pool != nil {
+			used, _ := pool.Conns()
+			writeMetric(&b, "nzbrefresh_connections_used", providerLabel(&providerList[n]), used)
+		}
+	}
+
+	writeMetricHeader(&b, "nzbrefresh_command_duration_seconds", "histogram", "Latency of the NNTP commands per provider.")
+	for n := range providerList {
+		m := &providerList[n].metrics
+		m.lock.Lock()
+		for _, command := range sortedKeys(m.latency) {
+			h := m.latency[command]
+			labels := fmt.Sprintf(`%s,command="%s"`, providerLabel(&providerList[n]), command)
+			var cumulative uint64
+			for i, bound := range latencyBuckets {
+				cumulative += h.counts[i]
+				writeMetric(&b, "nzbrefresh_command_duration_seconds_bucket", fmt.Sprintf(`%s,le="%v"`, labels, bound), cumulative)
+			}
+			writeMetric(&b, "nzbrefresh_command_duration_seconds_bucket", labels+`,le="+Inf"`, h.count)
+			writeMetric(&b, "nzbrefresh_command_duration_seconds_sum", labels, h.sum)
+			writeMetric(&b, "nzbrefresh_command_duration_seconds_count", labels, h.count)
+		}
+		m.lock.Unlock()
+	}
+
+	writeMetricHeader(&b, "nzbrefresh_run_segments", "gauge", "Number of segments of the current run.")
+	writeMetricHeader(&b, "nzbrefresh_run_segments_checked", "gauge", "Number of segments checked in the current run.")
+	if segments := runProgress.segments.Load(); segments > 0 {
+		writeMetric(&b, "nzbrefresh_run_segments", "", segments)
+		writeMetric(&b, "nzbrefresh_run_segments_checked", "", runProgress.segmentsChecked.Load())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
+
+func writeMetricHeader(b *strings.Builder, name, metricType, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeMetric(b *strings.Builder, name, labels string, value interface{}) {
+	if labels != "" {
+		fmt.Fprintf(b, "%s{%s} %v\n", name, labels, value)
+	} else {
+		fmt.Fprintf(b, "%s %v\n", name, value)
+	}
+}
+
+func providerLabel(provider *Provider) string {
+	return fmt.Sprintf(`provider="%s"`, strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(provider.Name))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventCompleted       = "completed"
+	eventUnrecoverable   = "unrecoverable"
+	eventProviderFailure = "provider_failure"
+	eventDead            = "dead"
+)
+
+// notification summary as sent to the webhooks and passed to the notification script
+type notification struct {
+	Event         string            `json:"event"`
+	Message       string            `json:"message"`
+	Time          time.Time         `json:"time"`
+	Nzb           string            `json:"nzb,omitempty"`
+	CheckOnly     bool              `json:"checkOnly"`
+	Segments      int               `json:"segments,omitempty"`
+	Unrecoverable uint64            `json:"unrecoverable"`
+	Provider      string            `json:"provider,omitempty"`
+	Providers     []historyProvider `json:"providers,omitempty"`
+}
+
+// notifications sent in the background while the run goes on
+var pendingNotifications sync.WaitGroup
+
+// notifyRunResults sends the notifications for the finished run
+func notifyRunResults(path string) {
+	// the notifications of providers which went offline come first
+	pendingNotifications.Wait()
+	if len(args.Webhook) == 0 && args.NotifyScript == "" {
+		return
+	}
+	report := runReport(path)
+	summary := notification{
+		Event:         eventCompleted,
+		Time:          time.Now(),
+		Nzb:           report.NzbName,
+		CheckOnly:     report.CheckOnly,
+		Segments:      report.TotalSegments,
+		Unrecoverable: runProgress.unrecoverable.Load(),
+		Providers:     report.Providers,
+	}
+	var results []string
+	for _, provider := range report.Providers {
+		results = append(results, fmt.Sprintf("%s: %v/%v available, %v refreshed", provider.Name, provider.Available, provider.Checked, provider.Refreshed))
+	}
+	summary.Message = fmt.Sprintf("Run for '%s' completed (%s)", summary.Nzb, strings.Join(results, " | "))
+	if report.Aborted {
+		summary.Event = eventDead
+		summary.Message = fmt.Sprintf("Run for '%s' aborted, the NZB file is beyond saving: %v segments are missing on all providers after checking %v of %v segments",
+			summary.Nzb, summary.Unrecoverable, runProgress.segmentsChecked.Load(), summary.Segments)
+	}
+	notify(summary)
+
+	if summary.Unrecoverable > 0 && !report.Aborted {
+		summary.Event = eventUnrecoverable
+		summary.Message = fmt.Sprintf("%v of %v segments of '%s' are missing on all providers and cannot be refreshed", summary.Unrecoverable, summary.Segments, summary.Nzb)
+		notify(summary)
+	}
+
+	// a provider failed if it could not check all the segments
+	for _, provider := range report.Providers {
+		// offline providers were already notified when they went offline
+		if provider.Offline {
+			continue
+		}
+		if checked := runProgress.segmentsChecked.Load(); checked > provider.Checked {
+			failed := checked - provider.Checked
+			summary.Event = eventProviderFailure
+			summary.Provider = provider.Name
+			summary.Message = fmt.Sprintf("Provider '%s' failed to check %v segments of '%s'", provider.Name, failed, summary.Nzb)
+			notify(summary)
+		}
+	}
+}
+
+// notifyProviderFailure sends the notifications for a provider which went offline.
+// They are sent in the background, so a slow webhook doesn't stall the segment check.
+func notifyProviderFailure(provider *Provider, err error) {
+	if len(args.Webhook) == 0 && args.NotifyScript == "" {
+		return
+	}
+	summary := notification{
+		Event:    eventProviderFailure,
+		Time:     time.Now(),
+		Provider: provider.Name,
+		Message:  fmt.Sprintf("Provider '%s' failed: %v", provider.Name, err),
+	}
+	pendingNotifications.Add(1)
+	go func() {
+		defer pendingNotifications.Done()
+		notify(summary)
+	}()
+}
+
+func notify(summary notification) {
+	for _, webhook := range args.Webhook {
+		if err := sendWebhook(webhook, summary); err != nil {
+			log.Print(fmt.Errorf("unable to send notification to webhook '%s': %v", redactURL(webhook), err))
+		}
+	}
+	if args.NotifyScript != "" {
+		if err := runNotifyScript(args.NotifyScript, summary); err != nil {
+			log.Print(fmt.Errorf("unable to run notification script '%s': %v", args.NotifyScript, err))
+		}
+	}
+}
+
+func sendWebhook(webhook string, summary notification) error {
+	format := args.WebhookFormat
+	if format == "" || format == "auto" {
+		format = webhookFormat(webhook)
+	}
+	var payload interface{}
+	switch format {
+	case "discord":
+		payload = map[string]string{"content": summary.Message}
+	case "slack":
+		payload = map[string]string{"text": summary.Message}
+	case "gotify":
+		payload = map[string]interface{}{"title": appName, "message": summary.Message, "priority": 5}
+	case "json":
+		payload = summary
+	default:
+		return fmt.Errorf("unknown webhook format '%s'", format)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	response, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", response.Status)
+	}
+	log.Printf("notification '%s' sent to webhook '%s'", summary.Event, redactURL(webhook))
+	return nil
+}
+
+// webhookFormat guesses the payload format from the webhook URL
+func webhookFormat(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return "json"
+	}
+	switch {
+	case strings.HasSuffix(u.Host, "discord.com") || strings.HasSuffix(u.Host, "discordapp.com"):
+		return "discord"
+	case u.Host == "hooks.slack.com":
+		return "slack"
+	case strings.HasSuffix(u.Path, "/message") && u.Query().Get("token") != "":
+		return "gotify"
+	default:
+		return "json"
+	}
+}
+
+// runNotifyScript executes the script with the summary in environment variables
+func runNotifyScript(script string, summary notification) error {
+	summaryJSON, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(script)
+	cmd.Env = append(os.Environ(),
+		"NZBREFRESH_EVENT="+summary.Event,
+		"NZBREFRESH_MESSAGE="+summary.Message,
+		"NZBREFRESH_NZB="+summary.Nzb,
+		fmt.Sprintf("NZBREFRESH_CHECK_ONLY=%v", summary.CheckOnly),
+		fmt.Sprintf("NZBREFRESH_SEGMENTS=%v", summary.Segments),
+		fmt.Sprintf("NZBREFRESH_UNRECOVERABLE=%v", summary.Unrecoverable),
+		"NZBREFRESH_PROVIDER="+summary.Provider,
+		"NZBREFRESH_SUMMARY="+string(summaryJSON),
+	)
+	for n, provider := range summary.Providers {
+		prefix := fmt.Sprintf("NZBREFRESH_PROVIDER_%v_", n+1)
+		cmd.Env = append(cmd.Env,
+			prefix+"NAME="+provider.Name,
+			fmt.Sprintf("%sCHECKED=%v", prefix, provider.Checked),
+			fmt.Sprintf("%sAVAILABLE=%v", prefix, provider.Available),
+			fmt.Sprintf("%sMISSING=%v", prefix, provider.Missing),
+			fmt.Sprintf("%sREFRESHED=%v", prefix, provider.Refreshed),
+		)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	log.Printf("notification script '%s' executed for event '%s'", filepath.Base(script), summary.Event)
+	return nil
+}
+
+// redactURL reduces the URL to scheme and host for logging, as webhook tokens are part of the path or query
+func redactURL(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return "<invalid url>"
+	}
+	return u.Scheme + "://" + u.Host
+}
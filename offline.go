@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Tensai75/nntp"
+	"github.com/Tensai75/nntpPool"
+)
+
+// newProviderPool creates the connection pool of the provider
+func newProviderPool(provider *Provider) (nntpPool.ConnectionPool, error) {
+	return nntpPool.New(&nntpPool.Config{
+		Name:                  provider.Name,
+		Host:                  provider.Host,
+		Port:                  provider.Port,
+		SSL:                   provider.SSL,
+		SkipSSLCheck:          provider.SkipSslCheck,
+		User:                  provider.Username,
+		Pass:                  provider.Password,
+		MaxConns:              provider.MaxConns,
+		ConnWaitTime:          time.Duration(provider.ConnWaitTime) * time.Second,
+		IdleTimeout:           time.Duration(provider.IdleTimeout) * time.Second,
+		HealthCheck:           provider.HealthCheck,
+		MaxTooManyConnsErrors: provider.MaxTooManyConnsErrors,
+		MaxConnErrors:         provider.MaxConnErrors,
+	}, 0)
+}
+
+// getPool returns the connection pool of the provider, which is replaced upon a reconnect
+func (p *Provider) getPool() nntpPool.ConnectionPool {
+	p.poolLock.RLock()
+	defer p.poolLock.RUnlock()
+	return p.pool
+}
+
+func (p *Provider) setPool(pool nntpPool.ConnectionPool) {
+	p.poolLock.Lock()
+	defer p.poolLock.Unlock()
+	p.pool = pool
+}
+
+// number of consecutive errors getting a connection from the pool until the provider is set offline
+const maxPoolErrors = 3
+
+// getConn gets a connection from the pool of the provider.
+// As the pool fails permanently once it cannot establish connections anymore, the provider is set offline upon repeated errors.
+func (p *Provider) getConn() (*nntpPool.NNTPConn, nntpPool.ConnectionPool, error) {
+	pool := p.getPool()
+	if pool == nil || p.isOffline() {
+		return nil, nil, fmt.Errorf("provider is offline")
+	}
+	if conn, err := pool.Get(context.TODO()); err != nil {
+		p.metrics.connectionError(err)
+		// the pool was closed as it was replaced upon a reconnect
+		if pool != p.getPool() {
+			return nil, nil, err
+		}
+		if errorCount := p.poolErrors.Add(1); errorCount >= maxPoolErrors {
+			p.setOffline(fmt.Errorf("%v consecutive connection errors, last error was: %v", errorCount, err))
+		}
+		return nil, nil, err
+	} else {
+		p.poolErrors.Store(0)
+		return conn, pool, nil
+	}
+}
+
+// commandResult counts the consecutive connection errors of the commands
+// and sets the provider offline if they reach MaxConnErrors (NNTP error responses are not counted)
+func (p *Provider) commandResult(err error) {
+	var nntpError nntp.Error
+	if err == nil || errors.As(err, &nntpError) {
+		p.consecutiveErrors.Store(0)
+		return
+	}
+	if errorCount := p.consecutiveErrors.Add(1); p.MaxConnErrors > 0 && errorCount >= p.MaxConnErrors {
+		p.setOffline(fmt.Errorf("%v consecutive errors, last error was: %v", errorCount, err))
+	}
+}
+
+func (p *Provider) isOffline() bool {
+	return p.offline.Load()
+}
+
+// offlineError returns the error which set the provider offline
+func (p *Provider) offlineError() error {
+	p.poolLock.RLock()
+	defer p.poolLock.RUnlock()
+	return p.offlineErr
+}
+
+// setOffline excludes the provider from the checks and uploads and, if configured, tries to reconnect later
+func (p *Provider) setOffline(err error) {
+	if !p.offline.CompareAndSwap(false, true) {
+		return
+	}
+	p.articles.offline.Store(true)
+	p.poolLock.Lock()
+	p.offlineErr = err
+	p.poolLock.Unlock()
+	log.Print(fmt.Errorf("provider '%s' is offline: %v", p.Name, err))
+	notifyProviderFailure(p, err)
+	if p.ReconnectWaitTime > 0 {
+		go p.reconnect()
+	}
+}
+
+// reconnect periodically tries to create a new connection pool until the provider is online again
+func (p *Provider) reconnect() {
+	for {
+		time.Sleep(time.Duration(p.ReconnectWaitTime) * time.Second)
+		log.Printf("reconnecting to provider '%s'", p.Name)
+		pool, err := newProviderPool(p)
+		if err == nil {
+			var ihave, post bool
+			if ihave, post, err = checkCapabilities(p, pool); err == nil {
+				// replace the failed pool
+				if oldPool := p.getPool(); oldPool != nil {
+					go oldPool.Close()
+				}
+				p.setPool(pool)
+				p.capabilities.ihave.Store(ihave)
+				p.capabilities.post.Store(post)
+				p.consecutiveErrors.Store(0)
+				p.poolErrors.Store(0)
+				p.offline.Store(false)
+				log.Printf("provider '%s' is online again", p.Name)
+				return
+			}
+			pool.Close()
+		}
+		log.Print(fmt.Errorf("unable to reconnect to provider '%s': %v", p.Name, err))
+	}
+}
+
+// connectionsUsed returns the maximum number of simultaneously used connections of the provider
+func (p *Provider) connectionsUsed() uint32 {
+	if pool := p.getPool(); pool != nil {
+		return pool.MaxConns()
+	}
+	return 0
+}
+
+// closePools closes the connection pools of all the providers
+func closePools() {
+	for n := range providerList {
+		if pool := providerList[n].getPool(); pool != nil {
+			go pool.Close()
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// PAR2 packets as specified in the Parity Volume Set Specification 2.0
+var (
+	par2Magic        = []byte("PAR2\x00PKT")
+	par2MainType     = []byte("PAR 2.0\x00Main\x00\x00\x00\x00")
+	par2FileDescType = []byte("PAR 2.0\x00FileDesc")
+	par2IFSCType     = []byte("PAR 2.0\x00IFSC\x00\x00\x00\x00")
+	par2RecvSlicType = []byte("PAR 2.0\x00RecvSlic")
+)
+
+const par2HeaderSize = 64
+
+// error returned if the available recovery slices cannot be combined to reconstruct the missing input slices
+var errPar2Dependent = fmt.Errorf("the recovery slices cannot be combined")
+
+type (
+	// recovery set of PAR2 files
+	par2Set struct {
+		id        [16]byte
+		sliceSize int64
+		fileIDs   [][16]byte               // files of the recovery set in the order of their input slices
+		files     map[[16]byte]*par2File   // by file ID
+		recovery  map[uint32]*par2Recovery // recovery slices by exponent
+	}
+
+	par2File struct {
+		name     string
+		size     int64
+		checksum [][16]byte // MD5 hashes of the input slices of the file
+	}
+
+	par2Recovery struct {
+		exponent uint32
+		data     []byte
+	}
+)
+
+func newPar2Set() *par2Set {
+	return &par2Set{
+		files:    make(map[[16]byte]*par2File),
+		recovery: make(map[uint32]*par2Recovery),
+	}
+}
+
+// addPackets adds the valid packets of the recovery set found in the data of a PAR2 file.
+// Packets damaged or missing in the data, e.g. of missing articles, are skipped.
+func (set *par2Set) addPackets(data []byte) {
+	for offset := 0; offset+par2HeaderSize <= len(data); {
+		if n := bytes.Index(data[offset:], par2Magic); n < 0 {
+			return
+		} else {
+			offset += n
+		}
+		length := binary.LittleEndian.Uint64(data[offset+8:])
+		if length < par2HeaderSize || length%4 != 0 || uint64(len(data)-offset) < length {
+			offset += len(par2Magic)
+			continue
+		}
+		packet := data[offset : offset+int(length)]
+		if md5.Sum(packet[32:]) != [16]byte(packet[16:32]) {
+			offset += len(par2Magic)
+			continue
+		}
+		set.addPacket(packet)
+		offset += int(length)
+	}
+}
+
+func (set *par2Set) addPacket(packet []byte) {
+	setID := [16]byte(packet[32:48])
+	packetType := packet[48:64]
+	body := packet[par2HeaderSize:]
+	if set.id != ([16]byte{}) && set.id != setID {
+		// packets of another recovery set
+		return
+	}
+	switch {
+	case bytes.Equal(packetType, par2MainType) && len(body) >= 12:
+		set.id = setID
+		set.sliceSize = int64(binary.LittleEndian.Uint64(body))
+		count := int(binary.LittleEndian.Uint32(body[8:]))
+		set.fileIDs = nil
+		for n := 0; n < count && 12+(n+1)*16 <= len(body); n++ {
+			set.fileIDs = append(set.fileIDs, [16]byte(body[12+n*16:]))
+		}
+	case bytes.Equal(packetType, par2FileDescType) && len(body) >= 56:
+		file := set.file([16]byte(body))
+		file.size = int64(binary.LittleEndian.Uint64(body[48:]))
+		file.name = strings.TrimRight(string(body[56:]), "\x00")
+	case bytes.Equal(packetType, par2IFSCType) && len(body) >= 16:
+		file := set.file([16]byte(body))
+		file.checksum = nil
+		for entry := body[16:]; len(entry) >= 20; entry = entry[20:] {
+			// the MD5 hash is followed by the CRC32 of the slice
+			file.checksum = append(file.checksum, [16]byte(entry))
+		}
+	case bytes.Equal(packetType, par2RecvSlicType) && len(body) >= 4:
+		exponent := binary.LittleEndian.Uint32(body)
+		set.recovery[exponent] = &par2Recovery{exponent: exponent, data: body[4:]}
+	}
+}
+
+func (set *par2Set) file(id [16]byte) *par2File {
+	if set.files[id] == nil {
+		set.files[id] = &par2File{}
+	}
+	return set.files[id]
+}
+
+// complete returns an error if the description of the recovery set is incomplete
+func (set *par2Set) complete() error {
+	if set.sliceSize == 0 || len(set.fileIDs) == 0 {
+		return fmt.Errorf("no main packet found")
+	}
+	if set.sliceSize%4 != 0 {
+		return fmt.Errorf("invalid slice size %v", set.sliceSize)
+	}
+	for _, id := range set.fileIDs {
+		file := set.files[id]
+		if file == nil || file.name == "" {
+			return fmt.Errorf("no description of file %x found", id)
+		}
+		if int64(len(file.checksum)) != (file.size+set.sliceSize-1)/set.sliceSize {
+			return fmt.Errorf("no slice checksums of file '%s' found", file.name)
+		}
+	}
+	return nil
+}
+
+// Galois field GF(2^16) with the generator polynomial of PAR2
+const (
+	gfPolynomial = 0x1100B
+	gfLimit      = 65535
+)
+
+var gfExp, gfLog = gfTables()
+
+func gfTables() ([]uint16, []uint16) {
+	exp := make([]uint16, 2*gfLimit)
+	log := make([]uint16, gfLimit+1)
+	x := 1
+	for n := 0; n < gfLimit; n++ {
+		exp[n] = uint16(x)
+		log[x] = uint16(n)
+		x <<= 1
+		if x&0x10000 != 0 {
+			x ^= gfPolynomial
+		}
+	}
+	// doubled, so the sum of two logarithms needs no modulo
+	copy(exp[gfLimit:], exp[:gfLimit])
+	return exp, log
+}
+
+func gfMul(a, b uint16) uint16 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a uint16) uint16 {
+	return gfExp[gfLimit-int(gfLog[a])]
+}
+
+// par2Bases returns the logarithms of the constants of the first count input slices,
+// which are the numbers coprime to 65535
+func par2Bases(count int) []int {
+	bases := make([]int, 0, count)
+	for n := 0; len(bases) < count; n++ {
+		if n%3 != 0 && n%5 != 0 && n%17 != 0 && n%257 != 0 {
+			bases = append(bases, n)
+		}
+	}
+	return bases
+}
+
+// par2Factor returns the factor of an input slice for the recovery slice with the exponent
+func par2Factor(base int, exponent uint32) uint16 {
+	return gfExp[(base*int(exponent%gfLimit))%gfLimit]
+}
+
+// gfMulAdd adds the slice multiplied by the factor to the sum, both as little endian 16 bit words
+func gfMulAdd(sum, slice []byte, factor uint16) {
+	if factor == 0 {
+		return
+	}
+	logFactor := int(gfLog[factor])
+	for n := 0; n+1 < len(slice) && n+1 < len(sum); n += 2 {
+		if word := binary.LittleEndian.Uint16(slice[n:]); word != 0 {
+			binary.LittleEndian.PutUint16(sum[n:], binary.LittleEndian.Uint16(sum[n:])^gfExp[int(gfLog[word])+logFactor])
+		}
+	}
+}
+
+// gfIndependentRows returns the indices of the first rows of the matrix which are linearly independent,
+// but not more than the limit
+func gfIndependentRows(matrix [][]uint16, limit int) []int {
+	var independent []int
+	var reduced [][]uint16 // rows already selected, reduced so that the first non-zero value is 1
+	var pivots []int       // column of the first non-zero value of each reduced row
+	for n := 0; n < len(matrix) && len(independent) < limit; n++ {
+		row := append([]uint16(nil), matrix[n]...)
+		for i, pivot := range pivots {
+			if factor := row[pivot]; factor != 0 {
+				for column := range row {
+					row[column] ^= gfMul(reduced[i][column], factor)
+				}
+			}
+		}
+		pivot := -1
+		for column, value := range row {
+			if value != 0 {
+				pivot = column
+				break
+			}
+		}
+		if pivot < 0 {
+			// the row is a combination of the rows already selected
+			continue
+		}
+		factor := gfInv(row[pivot])
+		for column := range row {
+			row[column] = gfMul(row[column], factor)
+		}
+		independent = append(independent, n)
+		reduced = append(reduced, row)
+		pivots = append(pivots, pivot)
+	}
+	return independent
+}
+
+// gfInvert inverts the square matrix with Gauss-Jordan elimination
+func gfInvert(matrix [][]uint16) ([][]uint16, error) {
+	size := len(matrix)
+	work := make([][]uint16, size)
+	inverse := make([][]uint16, size)
+	for n := range matrix {
+		work[n] = append([]uint16(nil), matrix[n]...)
+		inverse[n] = make([]uint16, size)
+		inverse[n][n] = 1
+	}
+	for column := 0; column < size; column++ {
+		pivot := -1
+		for row := column; row < size; row++ {
+			if work[row][column] != 0 {
+				pivot = row
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errPar2Dependent
+		}
+		work[column], work[pivot] = work[pivot], work[column]
+		inverse[column], inverse[pivot] = inverse[pivot], inverse[column]
+		factor := gfInv(work[column][column])
+		for n := 0; n < size; n++ {
+			work[column][n] = gfMul(work[column][n], factor)
+			inverse[column][n] = gfMul(inverse[column][n], factor)
+		}
+		for row := 0; row < size; row++ {
+			if row == column || work[row][column] == 0 {
+				continue
+			}
+			factor := work[row][column]
+			for n := 0; n < size; n++ {
+				work[row][n] ^= gfMul(work[column][n], factor)
+				inverse[row][n] ^= gfMul(inverse[column][n], factor)
+			}
+		}
+	}
+	return inverse, nil
+}
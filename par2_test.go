@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"slices"
+	"testing"
+)
+
+// gfMulSlow multiplies in GF(2^16) without the tables, as reference for the table based arithmetic
+func gfMulSlow(a, b uint16) uint16 {
+	var product uint32
+	x, y := uint32(a), uint32(b)
+	for y > 0 {
+		if y&1 != 0 {
+			product ^= x
+		}
+		y >>= 1
+		x <<= 1
+		if x&0x10000 != 0 {
+			x ^= gfPolynomial
+		}
+	}
+	return uint16(product)
+}
+
+// gfPowSlow returns 2 to the power of the exponent in GF(2^16) without the tables
+func gfPowSlow(exponent int) uint16 {
+	result := uint16(1)
+	for n := 0; n < exponent%gfLimit; n++ {
+		result = gfMulSlow(result, 2)
+	}
+	return result
+}
+
+func TestGfArithmetic(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for n := 0; n < 10000; n++ {
+		a, b := uint16(random.Intn(1<<16)), uint16(random.Intn(1<<16))
+		if got, want := gfMul(a, b), gfMulSlow(a, b); got != want {
+			t.Fatalf("gfMul(%v, %v) = %v, want %v", a, b, got, want)
+		}
+		if a != 0 && gfMul(a, gfInv(a)) != 1 {
+			t.Fatalf("gfInv(%v) = %v is not the inverse", a, gfInv(a))
+		}
+	}
+}
+
+func TestPar2Bases(t *testing.T) {
+	// the logarithms of the constants of the first input slices according to the PAR2 specification
+	want := []int{1, 2, 4, 7, 8, 11, 13, 14, 16, 19, 22, 23, 26, 28, 29, 31, 32, 37}
+	got := par2Bases(len(want))
+	for n := range want {
+		if got[n] != want[n] {
+			t.Fatalf("par2Bases() = %v, want %v", got, want)
+		}
+	}
+	if got, want := par2Factor(got[3], 5), gfPowSlow(7*5); got != want {
+		t.Fatalf("par2Factor(7, 5) = %v, want %v", got, want)
+	}
+}
+
+// par2Packet returns a PAR2 packet with the header and its MD5 hash
+func par2Packet(setID [16]byte, packetType []byte, body []byte) []byte {
+	packet := make([]byte, par2HeaderSize, par2HeaderSize+len(body))
+	copy(packet, par2Magic)
+	binary.LittleEndian.PutUint64(packet[8:], uint64(par2HeaderSize+len(body)))
+	copy(packet[32:], setID[:])
+	copy(packet[48:], packetType)
+	packet = append(packet, body...)
+	hash := md5.Sum(packet[32:])
+	copy(packet[16:], hash[:])
+	return packet
+}
+
+type testInputFile struct {
+	name string
+	data []byte
+}
+
+// testRecoverySet returns the packets of a recovery set of the files with the recovery slices of the exponents,
+// which are computed without the tables of the decoder
+func testRecoverySet(files []testInputFile, sliceSize int, exponents []uint32) []byte {
+	var fileIDs [][16]byte
+	for _, file := range files {
+		fileIDs = append(fileIDs, md5.Sum([]byte(file.name)))
+	}
+	main := binary.LittleEndian.AppendUint64(nil, uint64(sliceSize))
+	main = binary.LittleEndian.AppendUint32(main, uint32(len(files)))
+	for _, id := range fileIDs {
+		main = append(main, id[:]...)
+	}
+	setID := md5.Sum(main)
+	packets := par2Packet(setID, par2MainType, main)
+
+	var slices [][]byte
+	for n, file := range files {
+		name := []byte(file.name)
+		name = append(name, make([]byte, (4-len(name)%4)%4)...)
+		desc := append(append([]byte(nil), fileIDs[n][:]...), make([]byte, 32)...)
+		desc = binary.LittleEndian.AppendUint64(desc, uint64(len(file.data)))
+		packets = append(packets, par2Packet(setID, par2FileDescType, append(desc, name...))...)
+
+		ifsc := append([]byte(nil), fileIDs[n][:]...)
+		for offset := 0; offset < len(file.data); offset += sliceSize {
+			slice := make([]byte, sliceSize)
+			copy(slice, file.data[offset:])
+			hash := md5.Sum(slice)
+			ifsc = binary.LittleEndian.AppendUint32(append(ifsc, hash[:]...), crc32.ChecksumIEEE(slice))
+			slices = append(slices, slice)
+		}
+		packets = append(packets, par2Packet(setID, par2IFSCType, ifsc)...)
+	}
+
+	bases := par2Bases(len(slices))
+	for _, exponent := range exponents {
+		recovery := make([]byte, sliceSize)
+		for n, slice := range slices {
+			factor := gfPowSlow(bases[n] * int(exponent))
+			for i := 0; i < sliceSize; i += 2 {
+				word := gfMulSlow(binary.LittleEndian.Uint16(slice[i:]), factor)
+				binary.LittleEndian.PutUint16(recovery[i:], binary.LittleEndian.Uint16(recovery[i:])^word)
+			}
+		}
+		body := append(binary.LittleEndian.AppendUint32(nil, exponent), recovery...)
+		packets = append(packets, par2Packet(setID, par2RecvSlicType, body)...)
+	}
+	return packets
+}
+
+func TestReconstructSlices(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		exponents []uint32
+	}{
+		// some recovery volumes are missing, so the exponents are not consecutive
+		{name: "missing volumes", exponents: []uint32{0, 2, 3, 7, 8}},
+		// the exponents 65536 and 131071 result in the same factors, as the logarithms are taken modulo 65535,
+		// so the lowest exponents cannot be combined and the highest one is needed
+		{name: "dependent recovery slices", exponents: []uint32{65536, 65537, 131071, 131074, 131078}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testReconstructSlices(t, test.exponents)
+		})
+	}
+}
+
+func testReconstructSlices(t *testing.T, exponents []uint32) {
+	const sliceSize = 64
+	random := rand.New(rand.NewSource(2))
+	files := []testInputFile{
+		{name: "first.bin", data: make([]byte, 5*sliceSize+10)},
+		{name: "second.bin", data: make([]byte, 3*sliceSize)},
+	}
+	for _, file := range files {
+		random.Read(file.data)
+	}
+	packets := testRecoverySet(files, sliceSize, exponents)
+
+	set := newPar2Set()
+	// the packets are found in between damaged data
+	set.addPackets(append(append([]byte("garbage"), packets...), "PAR2\x00PKT"...))
+	if err := set.complete(); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.recovery) != len(exponents) {
+		t.Fatalf("%v recovery slices found, want %v", len(set.recovery), len(exponents))
+	}
+
+	// the first, a middle and the last (partial) slice of the first file and a slice of the second file are missing
+	unknown := []int{0, 3, 5, 7}
+	inputFiles := make(map[string]*os.File)
+	for _, file := range files {
+		f, err := os.CreateTemp(t.TempDir(), "input-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.Write(file.data); err != nil {
+			t.Fatal(err)
+		}
+		inputFiles[file.name] = f
+	}
+	for _, slice := range unknown {
+		par2File, n := set.sliceFile(slice)
+		offset := int64(n) * sliceSize
+		if _, err := inputFiles[par2File.name].WriteAt(make([]byte, min(sliceSize, par2File.size-offset)), offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := reconstructSlices(set, inputFiles, unknown); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(inputFiles[file.name].Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, file.data) {
+			t.Errorf("file '%s' was not reconstructed correctly", file.name)
+		}
+	}
+}
+
+func TestGfIndependentRows(t *testing.T) {
+	matrix := [][]uint16{
+		{1, 2, 3},
+		{gfMul(1, 5), gfMul(2, 5), gfMul(3, 5)}, // multiple of the first row
+		{0, 1, 1},
+		{1, 3, 2}, // sum of the first and the third row
+		{0, 0, 1},
+		{7, 8, 9},
+	}
+	if got, want := gfIndependentRows(matrix, 3), []int{0, 2, 4}; !slices.Equal(got, want) {
+		t.Fatalf("gfIndependentRows() = %v, want %v", got, want)
+	}
+	if got, want := gfIndependentRows(matrix[:4], 3), []int{0, 2}; !slices.Equal(got, want) {
+		t.Fatalf("gfIndependentRows() = %v, want %v", got, want)
+	}
+}
+
+func TestReconstructSlicesDamagedRecovery(t *testing.T) {
+	const sliceSize = 32
+	data := make([]byte, 4*sliceSize)
+	rand.New(rand.NewSource(3)).Read(data)
+	packets := testRecoverySet([]testInputFile{{name: "file.bin", data: data}}, sliceSize, []uint32{1})
+
+	// a damaged recovery packet is skipped
+	packets[len(packets)-1] ^= 0xff
+	set := newPar2Set()
+	set.addPackets(packets)
+	if err := set.complete(); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.recovery) != 0 {
+		t.Fatalf("damaged recovery slice was not skipped")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tensai75/nzbparser"
+)
+
+type (
+	// plan of a dry run, what would be re-uploaded
+	refreshPlan struct {
+		Nzb        string                `json:"nzb"`
+		Time       time.Time             `json:"time"`
+		Segments   []*planSegment        `json:"segments"`
+		Uploads    map[string]*planTotal `json:"uploads"` // by provider name
+		TotalBytes int64                 `json:"totalBytes"`
+
+		index map[string]*planSegment // segments by message ID
+	}
+
+	planSegment struct {
+		MessageID string       `json:"messageId"`
+		File      string       `json:"file"`
+		Number    int          `json:"number"`
+		MissingOn []string     `json:"missingOn"`
+		Source    string       `json:"source,omitempty"` // provider the article would be fetched from (or archive, local file or par2 files)
+		Uploads   []planUpload `json:"uploads,omitempty"`
+		Error     string       `json:"error,omitempty"`
+	}
+
+	planUpload struct {
+		Provider string              `json:"provider"`
+		Headers  map[string][]string `json:"headers"` // headers as they would be posted
+		Bytes    int                 `json:"bytes"`
+	}
+
+	planTotal struct {
+		Articles int   `json:"articles"`
+		Bytes    int64 `json:"bytes"`
+	}
+)
+
+var (
+	plan     refreshPlan
+	planLock sync.Mutex
+)
+
+func resetPlan(path string) {
+	planLock.Lock()
+	defer planLock.Unlock()
+	plan = refreshPlan{
+		Nzb:     filepath.Base(path),
+		Time:    time.Now(),
+		Uploads: make(map[string]*planTotal),
+		index:   make(map[string]*planSegment),
+	}
+}
+
+// planAddSegment adds a segment missing on at least one provider to the plan of the dry run
+func planAddSegment(segment nzbparser.NzbSegment, fileName string, missingOn []*Provider) {
+	if !args.DryRun {
+		return
+	}
+	entry := &planSegment{
+		MessageID: segment.Id,
+		File:      fileName,
+		Number:    segment.Number,
+	}
+	for _, provider := range missingOn {
+		entry.MissingOn = append(entry.MissingOn, provider.Name)
+	}
+	sort.Strings(entry.MissingOn)
+	planLock.Lock()
+	defer planLock.Unlock()
+	plan.Segments = append(plan.Segments, entry)
+	plan.index[segment.Id] = entry
+}
+
+// planSetSource sets the source of the article, the name of the provider or e.g. "archive"
+func planSetSource(messageID string, source string) {
+	if !args.DryRun {
+		return
+	}
+	planLock.Lock()
+	defer planLock.Unlock()
+	if entry, ok := plan.index[messageID]; ok {
+		entry.Source = source
+	}
+}
+
+func planSetError(messageID string, err error) {
+	if !args.DryRun {
+		return
+	}
+	planLock.Lock()
+	defer planLock.Unlock()
+	if entry, ok := plan.index[messageID]; ok {
+		entry.Error = err.Error()
+	}
+}
+
+func planAddUpload(messageID string, provider *Provider, headers map[string][]string, bytes int) {
+	planLock.Lock()
+	defer planLock.Unlock()
+	if entry, ok := plan.index[messageID]; ok {
+		entry.Uploads = append(entry.Uploads, planUpload{Provider: provider.Name, Headers: headers, Bytes: bytes})
+	}
+	if plan.Uploads[provider.Name] == nil {
+		plan.Uploads[provider.Name] = &planTotal{}
+	}
+	plan.Uploads[provider.Name].Articles++
+	plan.Uploads[provider.Name].Bytes += int64(bytes)
+	plan.TotalBytes += int64(bytes)
+}
+
+// writePlanFile writes the plan of the dry run and shows its summary
+func writePlanFile(path string) error {
+	if !args.DryRun {
+		return nil
+	}
+	planLock.Lock()
+	defer planLock.Unlock()
+	sort.Slice(plan.Segments, func(i, j int) bool {
+		if plan.Segments[i].File != plan.Segments[j].File {
+			return plan.Segments[i].File < plan.Segments[j].File
+		}
+		return plan.Segments[i].Number < plan.Segments[j].Number
+	})
+	var uploads []string
+	articles := 0
+	for _, providerName := range sortedKeys(plan.Uploads) {
+		uploads = append(uploads, fmt.Sprintf("'%s': %v articles (%v bytes)", providerName, plan.Uploads[providerName].Articles, plan.Uploads[providerName].Bytes))
+		articles += plan.Uploads[providerName].Articles
+	}
+	result := fmt.Sprintf("Dry run: %v uploads of %v bytes would be done", articles, plan.TotalBytes)
+	if len(uploads) > 0 {
+		result += ": " + strings.Join(uploads, " | ")
+	}
+	fmt.Println(result)
+	log.Print(result)
+
+	planFileName := args.Plan
+	if planFileName == "" {
+		planFileName = strings.TrimSuffix(filepath.Base(path), filepath.Ext(filepath.Base(path))) + ".plan.json"
+	}
+	if file, err := json.MarshalIndent(plan, "", "  "); err != nil {
+		return fmt.Errorf("unable to encode the plan: %v", err)
+	} else if err := os.WriteFile(planFileName, file, 0644); err != nil {
+		return fmt.Errorf("unable to write the plan file '%s': %v", planFileName, err)
+	}
+	fmt.Printf("Plan written to '%s'\n", planFileName)
+	log.Printf("plan written to '%s'", planFileName)
+	return nil
+}
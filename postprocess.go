@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// information about the downloader calling nzbrefresh as post-processing or queue script
+type downloader struct {
+	name     string
+	nzbPath  string
+	jobName  string
+	category string
+	failed   bool // download or post-processing of the job failed
+	queue    bool // called as queue script before the download
+	skip     bool // event which does not require a refresh
+
+	optionPrefix string // prefix of the script option environment variables
+	exitSuccess  int
+	exitFailure  int
+	exitNone     int
+	info         string // prefixes of the output lines
+	warning      string
+	error        string
+}
+
+// isPostProcessScript returns true if nzbrefresh is called as script by SABnzbd or NZBGet
+func isPostProcessScript() bool {
+	return os.Getenv("NZBPP_NZBNAME") != "" || os.Getenv("NZBNA_EVENT") != "" || os.Getenv("SAB_VERSION") != ""
+}
+
+func detectDownloader() *downloader {
+	switch {
+	case os.Getenv("NZBPP_NZBNAME") != "":
+		// NZBGet post-processing script
+		d := newNZBGetDownloader()
+		d.jobName = os.Getenv("NZBPP_NZBNAME")
+		d.category = os.Getenv("NZBPP_CATEGORY")
+		d.nzbPath = findNZBGetNzbFile(os.Getenv("NZBPP_NZBFILENAME"))
+		status := os.Getenv("NZBPP_TOTALSTATUS")
+		d.failed = status != "" && status != "SUCCESS"
+		return d
+	case os.Getenv("NZBNA_EVENT") != "":
+		// NZBGet queue script, refresh newly added NZB files before they are downloaded
+		d := newNZBGetDownloader()
+		d.jobName = os.Getenv("NZBNA_NZBNAME")
+		d.category = os.Getenv("NZBNA_CATEGORY")
+		d.nzbPath = findNZBGetNzbFile(os.Getenv("NZBNA_FILENAME"))
+		d.queue = true
+		d.skip = os.Getenv("NZBNA_EVENT") != "NZB_ADDED"
+		d.exitSuccess, d.exitFailure, d.exitNone = 0, 0, 0
+		return d
+	default:
+		// SABnzbd post-processing script
+		d := &downloader{
+			name:         "SABnzbd",
+			optionPrefix: "NZBREFRESH_",
+			exitSuccess:  0,
+			exitFailure:  1,
+			exitNone:     0,
+		}
+		d.jobName = os.Getenv("SAB_FINAL_NAME")
+		if d.jobName == "" && len(os.Args) > 3 {
+			d.jobName = os.Args[3]
+		}
+		d.category = os.Getenv("SAB_CAT")
+		d.nzbPath = os.Getenv("SAB_ORIG_NZB_GZ")
+		status := os.Getenv("SAB_PP_STATUS")
+		if status == "" && len(os.Args) > 7 {
+			status = os.Args[7]
+		}
+		if value, err := strconv.Atoi(status); err == nil {
+			d.failed = value != 0
+		}
+		return d
+	}
+}
+
+func newNZBGetDownloader() *downloader {
+	return &downloader{
+		name:         "NZBGet",
+		optionPrefix: "NZBPO_",
+		exitSuccess:  93,
+		exitFailure:  94,
+		exitNone:     95,
+		info:         "[INFO] ",
+		warning:      "[WARNING] ",
+		error:        "[ERROR] ",
+	}
+}
+
+// findNZBGetNzbFile returns the path of the NZB file, which NZBGet renames after it was added to the queue
+func findNZBGetNzbFile(path string) string {
+	candidates := []string{path, path + ".queued", path + ".processed"}
+	if nzbDir := os.Getenv("NZBOP_NZBDIR"); nzbDir != "" && !filepath.IsAbs(path) {
+		name := filepath.Join(nzbDir, filepath.Base(path))
+		candidates = append(candidates, name, name+".queued", name+".processed")
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	return path
+}
+
+// option returns the value of a script option (NZBPO_NAME for NZBGet, NZBREFRESH_NAME otherwise)
+func (d *downloader) option(name string) string {
+	return strings.TrimSpace(os.Getenv(d.optionPrefix + strings.ToUpper(name)))
+}
+
+func (d *downloader) boolOption(name string) bool {
+	switch strings.ToLower(d.option(name)) {
+	case "yes", "true", "1":
+		return true
+	}
+	return false
+}
+
+func runPostProcess() {
+	d := detectDownloader()
+	fatalExitCode = d.exitFailure
+	fatalPrefix = d.error
+
+	if d.skip {
+		os.Exit(d.exitNone)
+	}
+	if d.boolOption("OnlyFailed") && !d.failed && !d.queue {
+		fmt.Printf("%sDownload of '%s' was successful, no refresh required\n", d.info, d.jobName)
+		os.Exit(d.exitNone)
+	}
+
+	// settings from the script options, which are not overwritten by the environment and the config file
+	var scriptFlags []string
+	if value := d.option("Provider"); value != "" {
+		args.Provider = value
+		scriptFlags = append(scriptFlags, "--provider")
+	}
+	if d.option("CheckOnly") != "" {
+		args.CheckOnly = d.boolOption("CheckOnly")
+		scriptFlags = append(scriptFlags, "--check")
+	}
+	if d.option("Debug") != "" {
+		args.Debug = d.boolOption("Debug")
+		scriptFlags = append(scriptFlags, "--debug")
+	}
+	if value := d.option("History"); value != "" {
+		args.History = value
+		scriptFlags = append(scriptFlags, "--history")
+	}
+	if value := d.option("Config"); value != "" {
+		args.Config = value
+		scriptFlags = append(scriptFlags, "--config")
+	}
+	args.NZBFile = d.nzbPath
+	if err := applySettings(&args, scriptFlags); err != nil {
+		exit(err)
+	}
+	// without a provider config file, the one next to the executable or the servers of NZBGet are used
+	defaultProvider := args.Provider == ""
+	if defaultProvider {
+		if executable, err := os.Executable(); err == nil {
+			args.Provider = filepath.Join(filepath.Dir(executable), "provider.json")
+		}
+	}
+	// the other arguments get the same defaults as with the main command
+	setDefaultArguments()
+
+	// the progress bars would only clutter the log of the downloader
+	progressBarsParam.Out = io.Discard
+
+	setupLogging(args.NZBFile, args.Debug)
+	log.Printf("running as %s script for '%s' (category: '%s')", d.name, d.jobName, d.category)
+	fmt.Printf("%sRefreshing '%s' with %s\n", d.info, d.jobName, args.Version())
+
+	preparationStartTime = time.Now()
+	if args.NZBFile == "" {
+		exit(fmt.Errorf("no NZB file provided by %s", d.name))
+	}
+	if nzbfile, err = loadNzbFile(args.NZBFile); err != nil {
+		exit(fmt.Errorf("unable to load NZB file '%s': %v", args.NZBFile, err))
+	}
+
+	// reuse the server configuration of NZBGet if no provider config file is set and there is none next to the executable
+	if _, err := os.Stat(args.Provider); err != nil {
+		if !defaultProvider {
+			exit(fmt.Errorf("unable to load provider list: %v", err))
+		}
+		if providerList = nzbgetProviders(); len(providerList) == 0 {
+			exit(fmt.Errorf("unable to load provider list: %v", err))
+		}
+		log.Printf("using the %v servers configured in NZBGet", len(providerList))
+		startProviders()
+	} else {
+		setupProviders(args.Provider)
+	}
+
+	if err := checkNzbFile(context.Background(), args.NZBFile); err != nil {
+		exit(err)
+	}
+	closePools()
+
+	if unrecoverable := runProgress.unrecoverable.Load(); unrecoverable > 0 {
+		fmt.Printf("%s%v of %v segments are missing on all providers and cannot be refreshed\n", d.error, unrecoverable, nzbfile.TotalSegments)
+		os.Exit(d.exitFailure)
+	}
+	var refreshed uint64
+	for n := range providerList {
+		refreshed += providerList[n].articles.refreshed.Load()
+	}
+	if args.CheckOnly {
+		fmt.Printf("%sAll %v segments are available on at least one provider\n", d.info, nzbfile.TotalSegments)
+	} else {
+		fmt.Printf("%sRefresh completed, %v articles re-uploaded\n", d.info, refreshed)
+	}
+	os.Exit(d.exitSuccess)
+}
+
+// nzbgetProviders returns the active news servers configured in NZBGet
+func nzbgetProviders() []Provider {
+	var providers []Provider
+	for n := 1; ; n++ {
+		prefix := fmt.Sprintf("NZBOP_SERVER%v_", n)
+		host := os.Getenv(prefix + "HOST")
+		if host == "" {
+			break
+		}
+		if strings.EqualFold(os.Getenv(prefix+"ACTIVE"), "no") {
+			continue
+		}
+		name := os.Getenv(prefix + "NAME")
+		if name == "" {
+			name = host
+		}
+		port, _ := strconv.ParseUint(os.Getenv(prefix+"PORT"), 10, 32)
+		if port == 0 {
+			port = 119
+		}
+		addSecret(os.Getenv(prefix + "PASSWORD"))
+		conns, _ := strconv.ParseUint(os.Getenv(prefix+"CONNECTIONS"), 10, 32)
+		if conns == 0 {
+			conns = 1
+		}
+		providers = append(providers, Provider{
+			Name:                  name,
+			Host:                  host,
+			Port:                  uint32(port),
+			SSL:                   strings.EqualFold(os.Getenv(prefix+"ENCRYPTION"), "yes"),
+			SkipSslCheck:          strings.EqualFold(os.Getenv(prefix+"CERTVERIFICATION"), "none") || strings.EqualFold(os.Getenv(prefix+"CERTVERIFICATION"), "no"),
+			Username:              os.Getenv(prefix + "USERNAME"),
+			Password:              os.Getenv(prefix + "PASSWORD"),
+			MaxConns:              uint32(conns),
+			ConnWaitTime:          10,
+			IdleTimeout:           30,
+			MaxTooManyConnsErrors: 3,
+			MaxConnErrors:         3,
+		})
+	}
+	return providers
+}
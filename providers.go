@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Tensai75/nntp"
+)
+
+// number of DATE commands to measure the command latency
+const latencyCommands = 5
+
+// results of the diagnostics of a provider
+type providerTestResult struct {
+	connect      time.Duration
+	authenticate time.Duration
+	latency      time.Duration
+	ihave        bool
+	post         bool
+	postCheck    string
+	maxConns     int
+	maxConnsErr  error
+	statCount    int
+	statFound    atomic.Uint64
+	statErrors   atomic.Uint64
+	statDuration time.Duration
+	statConns    int
+}
+
+// runProvidersTest connects to each provider and reports its capabilities and performance
+func runProvidersTest() {
+	setupLogging("providers", providersArgs.Debug)
+
+	if providerList, err = loadProviderList(providersArgs.Provider); err != nil {
+		exit(fmt.Errorf("unable to load provider list: %v", err))
+	}
+
+	var messageIDs []string
+	if providersArgs.NZBFile != "" {
+		if nzb, err := loadNzbFile(providersArgs.NZBFile); err != nil {
+			exit(fmt.Errorf("unable to load NZB file '%s': %v", providersArgs.NZBFile, err))
+		} else {
+			for _, file := range nzb.Files {
+				for _, segment := range file.Segments {
+					messageIDs = append(messageIDs, segment.Id)
+				}
+			}
+		}
+	}
+
+	var failed int
+	for n := range providerList {
+		provider := &providerList[n]
+		fmt.Printf("Testing provider '%s' (%s:%v, SSL: %v)\n", provider.Name, provider.Host, provider.Port, provider.SSL)
+		if result, err := testProvider(provider, messageIDs); err != nil {
+			failed++
+			fmt.Printf("   failed: %v\n", err)
+			log.Print(fmt.Errorf("provider '%s' failed: %v", provider.Name, err))
+		} else {
+			printProviderTestResult(provider, result)
+		}
+		fmt.Println()
+	}
+
+	if failed > 0 {
+		fmt.Printf("%v of %v providers failed\n", failed, len(providerList))
+		os.Exit(1)
+	}
+	fmt.Printf("All %v providers passed\n", len(providerList))
+}
+
+func testProvider(provider *Provider, messageIDs []string) (*providerTestResult, error) {
+	result := &providerTestResult{}
+
+	// connect and authenticate
+	start := time.Now()
+	conn, err := dialProvider(provider)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect: %v", err)
+	}
+	result.connect = time.Since(start)
+	start = time.Now()
+	if err := conn.Authenticate(provider.Username, provider.Password); err != nil {
+		conn.Quit()
+		return nil, fmt.Errorf("unable to authenticate: %v", err)
+	}
+	result.authenticate = time.Since(start)
+	conns := []*nntp.Conn{conn}
+	defer func() {
+		for _, conn := range conns {
+			conn.Quit()
+		}
+	}()
+
+	result.ihave, result.post = connCapabilities(conn)
+
+	// command latency
+	start = time.Now()
+	for i := 0; i < latencyCommands; i++ {
+		if _, err := conn.Date(); err != nil {
+			return nil, fmt.Errorf("unable to execute the DATE command: %v", err)
+		}
+	}
+	result.latency = time.Since(start) / latencyCommands
+
+	result.postCheck = checkPostPermission(conn)
+
+	// open connections until the provider refuses or the limit is reached
+	limit := int(providersArgs.MaxConns)
+	if limit == 0 {
+		limit = int(provider.MaxConns) * 2
+	}
+	for len(conns) < limit {
+		if conn, err := dialProvider(provider); err != nil {
+			result.maxConnsErr = err
+			break
+		} else if err := conn.Authenticate(provider.Username, provider.Password); err != nil {
+			conn.Quit()
+			result.maxConnsErr = err
+			break
+		} else {
+			conns = append(conns, conn)
+		}
+	}
+	result.maxConns = len(conns)
+	log.Printf("provider '%s': %v connections opened (limit: %v, error: %v)", provider.Name, result.maxConns, limit, result.maxConnsErr)
+
+	// STAT throughput over the configured number of connections
+	result.statCount = providersArgs.Stat
+	result.statConns = min(len(conns), int(max(provider.MaxConns, 1)))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	start = time.Now()
+	for _, conn := range conns[:result.statConns] {
+		wg.Add(1)
+		go func(conn *nntp.Conn) {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= result.statCount {
+					return
+				}
+				var messageID string
+				if len(messageIDs) > 0 {
+					messageID = messageIDs[i%len(messageIDs)]
+				} else {
+					messageID = fmt.Sprintf("nzbrefresh-test-%v-%v@nzbrefresh", time.Now().UnixNano(), i)
+				}
+				var nntpError nntp.Error
+				if _, _, err := conn.Stat("<" + messageID + ">"); err == nil {
+					result.statFound.Add(1)
+				} else if !errors.As(err, &nntpError) || nntpError.Code != 430 {
+					result.statErrors.Add(1)
+					log.Print(fmt.Errorf("unable to check article <%s> on provider '%s': %v", messageID, provider.Name, err))
+				}
+			}
+		}(conn)
+	}
+	wg.Wait()
+	result.statDuration = time.Since(start)
+
+	return result, nil
+}
+
+func dialProvider(provider *Provider) (*nntp.Conn, error) {
+	address := fmt.Sprintf("%v:%v", provider.Host, provider.Port)
+	if provider.SSL {
+		return nntp.DialTLS("tcp", address, &tls.Config{InsecureSkipVerify: provider.SkipSslCheck})
+	}
+	return nntp.Dial("tcp", address)
+}
+
+// checkPostPermission sends an empty article, which is always rejected,
+// to check whether the account is allowed to post without actually posting anything
+func checkPostPermission(conn *nntp.Conn) string {
+	var nntpError nntp.Error
+	err := conn.RawPost(strings.NewReader(""))
+	switch {
+	case err == nil:
+		return "allowed"
+	case errors.As(err, &nntpError) && nntpError.Code == 440:
+		return "not allowed"
+	case errors.As(err, &nntpError) && nntpError.Code/100 == 4 && nntpError.Code != 480:
+		// the empty article was rejected after the server accepted the POST command
+		return "allowed"
+	default:
+		return fmt.Sprintf("unknown (%v)", err)
+	}
+}
+
+func printProviderTestResult(provider *Provider, result *providerTestResult) {
+	fmt.Printf("   connect:          %v\n", result.connect.Round(time.Microsecond))
+	fmt.Printf("   authenticate:     %v\n", result.authenticate.Round(time.Microsecond))
+	fmt.Printf("   command latency:  %v (average of %v DATE commands)\n", result.latency.Round(time.Microsecond), latencyCommands)
+	fmt.Printf("   capabilities:     IHAVE: %v | POST: %v\n", result.ihave, result.post)
+	fmt.Printf("   POST permission:  %v\n", result.postCheck)
+	if result.maxConnsErr != nil {
+		fmt.Printf("   max connections:  %v (configured: %v, next connection failed: %v)\n", result.maxConns, provider.MaxConns, result.maxConnsErr)
+	} else {
+		fmt.Printf("   max connections:  at least %v (configured: %v)\n", result.maxConns, provider.MaxConns)
+	}
+	if result.statCount > 0 {
+		seconds := result.statDuration.Seconds()
+		fmt.Printf("   STAT throughput:  %.0f commands/s (%v commands on %v connections, %v found, %v errors)\n",
+			float64(result.statCount)/seconds, result.statCount, result.statConns, result.statFound.Load(), result.statErrors.Load())
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Tensai75/nntp"
+	"github.com/Tensai75/nzbparser"
+)
+
+// number of other articles of a file tried to determine the layout of its yEnc parts
+const maxLayoutAttempts = 5
+
+// layout of the yEnc parts of a file, taken from another article of the file
+type fileLayout struct {
+	name     string
+	total    int
+	fileSize int64
+	partSize int64
+	line     int
+	header   map[string][]string // headers of the reference article
+
+	// the part of the reference article, to check that the local file is the same
+	refBegin int64
+	refSize  int64
+	refCRC   uint32
+	hasCRC   bool
+}
+
+// layout of a file, determined once per run
+type fileLayoutEntry struct {
+	once   sync.Once
+	layout *fileLayout
+	err    error
+}
+
+var (
+	fileLayouts     = make(map[string]*fileLayoutEntry) // by file name of the NZB file
+	fileLayoutsLock sync.Mutex
+	sourceFiles     map[string]string // local source files by name, searched once per run
+	sourceFilesLock sync.Mutex
+	regenerated     atomic.Uint64 // number of articles regenerated from the local files in the current run
+)
+
+func resetRegenerated() {
+	fileLayoutsLock.Lock()
+	fileLayouts = make(map[string]*fileLayoutEntry)
+	fileLayoutsLock.Unlock()
+	// files may have been added to the source directory since the last run
+	sourceFilesLock.Lock()
+	sourceFiles = nil
+	sourceFilesLock.Unlock()
+	regenerated.Store(0)
+}
+
+// regenerateArticle re-encodes an article missing on all providers from the local source file and posts it
+// under its original message ID. It returns false if the article cannot be regenerated.
+func regenerateArticle(segment nzbparser.NzbSegment, fileName string, missingOn []*Provider) bool {
+	if args.SourceDir == "" || args.Export != "" {
+		return false
+	}
+	article, err := regeneratedArticle(segment, fileName)
+	if err != nil {
+		log.Print(fmt.Errorf("unable to regenerate article <%s>: %v", segment.Id, err))
+		return false
+	}
+	log.Printf("re-posting article <%s> regenerated from the local file '%s'", segment.Id, fileName)
+	if seedArticle(article, segment.Id, "local file", missingOn) {
+		regenerated.Add(1)
+	}
+	return true
+}
+
+// regeneratedArticle encodes the part of the local file with the layout and the headers of the other articles of the file
+func regeneratedArticle(segment nzbparser.NzbSegment, fileName string) (*nntp.Article, error) {
+	file := nzbFileByName(fileName)
+	if file == nil {
+		return nil, fmt.Errorf("file '%s' not found in the NZB file", fileName)
+	}
+	layout, err := getFileLayout(file, segment.Number)
+	if err != nil {
+		return nil, err
+	}
+	path, err := findSourceFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil {
+		return nil, err
+	} else if info.Size() != layout.fileSize {
+		return nil, fmt.Errorf("local file '%s' has %v bytes instead of %v", path, info.Size(), layout.fileSize)
+	}
+	if layout.hasCRC {
+		data := make([]byte, layout.refSize)
+		if _, err := f.ReadAt(data, layout.refBegin-1); err != nil {
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(data) != layout.refCRC {
+			return nil, fmt.Errorf("local file '%s' doesn't match the other articles of the file", path)
+		}
+	}
+	return encodeArticle(segment, file, layout, f)
+}
+
+// encodeArticle encodes the part of the file read from r as article of the segment
+func encodeArticle(segment nzbparser.NzbSegment, file *nzbparser.NzbFile, layout *fileLayout, r io.ReaderAt) (*nntp.Article, error) {
+	begin, end, err := layout.partRange(segment.Number)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, end-begin+1)
+	if _, err := r.ReadAt(data, begin-1); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	article := &nntp.Article{Header: make(map[string][]string)}
+	for name, values := range layout.header {
+		article.Header[name] = append([]string(nil), values...)
+	}
+	article.Header["Message-Id"] = []string{"<" + segment.Id + ">"}
+	if subject := firstValue(article.Header["Subject"]); subject != "" {
+		article.Header["Subject"] = []string{renumberSubject(subject, segment.Number, layout.total)}
+	}
+	article.Body = bytes.NewReader(encodeYenc(layout.name, segment.Number, layout.total, layout.fileSize, begin, data, layout.line))
+	// the regenerated article must pass the same checks as a downloaded one
+	if err := validateArticle(article, segment, file.Subject); err != nil {
+		return nil, fmt.Errorf("regenerated article doesn't match the NZB file: %v", err)
+	}
+	return article, nil
+}
+
+// partRange returns the offsets of the yEnc part in the file, starting with 1
+func (layout *fileLayout) partRange(number int) (int64, int64, error) {
+	begin := int64(number-1)*layout.partSize + 1
+	if number < 1 || number > layout.total || begin > layout.fileSize {
+		return 0, 0, fmt.Errorf("segment number %v is outside of the %v yEnc parts", number, layout.total)
+	}
+	return begin, min(begin+layout.partSize-1, layout.fileSize), nil
+}
+
+// getFileLayout returns the layout of the yEnc parts of the file, determined once per run.
+// Only the articles of the same file wait while the layout is loaded.
+func getFileLayout(file *nzbparser.NzbFile, missing int) (*fileLayout, error) {
+	fileLayoutsLock.Lock()
+	entry, ok := fileLayouts[file.Filename]
+	if !ok {
+		entry = &fileLayoutEntry{}
+		fileLayouts[file.Filename] = entry
+	}
+	fileLayoutsLock.Unlock()
+	loaded := false
+	entry.once.Do(func() {
+		entry.layout, entry.err = loadFileLayout(file, missing)
+		loaded = true
+	})
+	if entry.err != nil && !loaded {
+		return nil, fmt.Errorf("the yEnc parts of file '%s' are unknown", file.Filename)
+	}
+	return entry.layout, entry.err
+}
+
+// loadFileLayout determines the layout of the yEnc parts of the file from one of its other articles
+func loadFileLayout(file *nzbparser.NzbFile, missing int) (*fileLayout, error) {
+	providers := make([]*Provider, 0, len(providerList))
+	for n := range providerList {
+		providers = append(providers, &providerList[n])
+	}
+	attempts := 0
+	for _, segment := range file.Segments {
+		if segment.Number == missing {
+			continue
+		}
+		if attempts++; attempts > maxLayoutAttempts {
+			break
+		}
+		article, err := loadArticle(providers, segment, file.Subject)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		body, err := io.ReadAll(article.Body)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		yenc, err := decodeYenc(body)
+		if err != nil {
+			log.Print(fmt.Errorf("unable to decode article <%s>: %v", segment.Id, err))
+			continue
+		}
+		layout := &fileLayout{
+			name:     yenc.name,
+			total:    max(yenc.total, 1),
+			fileSize: yenc.fileSize,
+			line:     yenc.line,
+			header:   article.Header,
+			refBegin: max(yenc.begin, 1),
+			refSize:  int64(len(yenc.data)),
+			refCRC:   yenc.crc,
+			hasCRC:   yenc.hasCRC,
+		}
+		switch {
+		case layout.total == 1:
+			layout.partSize = layout.fileSize
+		case yenc.part < yenc.total:
+			layout.partSize = yenc.end - yenc.begin + 1
+		default:
+			// the last part begins after all the other parts of equal size
+			layout.partSize = (yenc.begin - 1) / int64(yenc.total-1)
+		}
+		if layout.line == 0 {
+			layout.line = 128
+		}
+		if layout.name == "" || layout.fileSize == 0 || layout.partSize == 0 {
+			log.Printf("article <%s> has incomplete yEnc headers", segment.Id)
+			continue
+		}
+		log.Printf("yEnc parts of file '%s' taken from article <%s>: %v parts of %v bytes, file size %v bytes", file.Filename, segment.Id, layout.total, layout.partSize, layout.fileSize)
+		return layout, nil
+	}
+	return nil, fmt.Errorf("unable to determine the yEnc parts of file '%s', none of its other articles is available", file.Filename)
+}
+
+// findSourceFile returns the path of the local source file with the name, searched in the source directory and its subdirectories
+func findSourceFile(name string) (string, error) {
+	sourceFilesLock.Lock()
+	defer sourceFilesLock.Unlock()
+	if sourceFiles == nil {
+		files := make(map[string]string)
+		if err := filepath.WalkDir(args.SourceDir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if _, ok := files[entry.Name()]; !ok && !entry.IsDir() {
+				files[entry.Name()] = path
+			}
+			return nil
+		}); err != nil {
+			return "", fmt.Errorf("unable to read the source directory '%s': %v", args.SourceDir, err)
+		}
+		sourceFiles = files
+	}
+	if path, ok := sourceFiles[name]; ok {
+		return path, nil
+	}
+	return "", fmt.Errorf("file '%s' not found in the source directory '%s'", name, args.SourceDir)
+}
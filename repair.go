@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Tensai75/nzbparser"
+)
+
+// segment missing on all providers to be reconstructed with the par2 files after the segment check
+type par2Candidate struct {
+	segment   nzbparser.NzbSegment
+	fileName  string
+	missingOn []*Provider
+}
+
+var (
+	par2Candidates     []par2Candidate
+	par2Damaged        map[string]bool // par2 files with articles missing on all providers
+	par2CandidatesLock sync.Mutex
+	reconstructed      atomic.Uint64 // number of articles reconstructed with the par2 files in the current run
+)
+
+func resetPar2Candidates() {
+	par2CandidatesLock.Lock()
+	defer par2CandidatesLock.Unlock()
+	par2Candidates = nil
+	par2Damaged = make(map[string]bool)
+	reconstructed.Store(0)
+}
+
+// addPar2Candidate adds a segment missing on all providers to the reconstruction after the segment check.
+// It returns false if the reconstruction with the par2 files is not enabled.
+func addPar2Candidate(segment nzbparser.NzbSegment, fileName string, missingOn []*Provider) bool {
+	if !args.Par2 || args.Export != "" {
+		return false
+	}
+	par2CandidatesLock.Lock()
+	defer par2CandidatesLock.Unlock()
+	if isPar2File(fileName) {
+		// the par2 files themselves are not reconstructed
+		par2Damaged[fileName] = true
+		return false
+	}
+	par2Candidates = append(par2Candidates, par2Candidate{segment, fileName, missingOn})
+	return true
+}
+
+func isPar2File(fileName string) bool {
+	return strings.HasSuffix(strings.ToLower(fileName), ".par2")
+}
+
+// repairWithPar2 reconstructs the segments missing on all providers with the par2 files and re-posts them.
+// In a dry run, the reconstruction is only planned.
+func repairWithPar2(ctx context.Context) {
+	par2CandidatesLock.Lock()
+	candidates := par2Candidates
+	par2CandidatesLock.Unlock()
+	if len(candidates) == 0 {
+		return
+	}
+	var err error
+	if args.DryRun {
+		log.Printf("planning the reconstruction of %v articles missing on all providers with the par2 files", len(candidates))
+		err = planReconstruction(ctx, candidates)
+	} else {
+		log.Printf("reconstructing %v articles missing on all providers with the par2 files", len(candidates))
+		err = reconstructArticles(ctx, candidates)
+	}
+	if errors.Is(err, context.Canceled) {
+		log.Print("reconstruction with the par2 files cancelled")
+	} else if err != nil {
+		err = fmt.Errorf("unable to reconstruct the articles missing on all providers with the par2 files: %v", err)
+		log.Print(err)
+		for _, candidate := range candidates {
+			planSetError(candidate.segment.Id, err)
+		}
+	}
+}
+
+// par2Files returns the par2 index files and the recovery volumes of the NZB file, the smallest volumes first
+func par2Files() ([]*nzbparser.NzbFile, []*nzbparser.NzbFile, error) {
+	var indexFiles, volumeFiles []*nzbparser.NzbFile
+	for n := range nzbfile.Files {
+		if isPar2Volume(nzbfile.Files[n].Filename) {
+			volumeFiles = append(volumeFiles, &nzbfile.Files[n])
+		} else if isPar2File(nzbfile.Files[n].Filename) {
+			indexFiles = append(indexFiles, &nzbfile.Files[n])
+		}
+	}
+	if len(indexFiles)+len(volumeFiles) == 0 {
+		return nil, nil, fmt.Errorf("the NZB file contains no par2 files")
+	}
+	// the smallest volumes first, as only as many recovery slices as missing input slices are needed
+	sort.SliceStable(volumeFiles, func(i, j int) bool {
+		return nzbFileBytes(volumeFiles[i]) < nzbFileBytes(volumeFiles[j])
+	})
+	return indexFiles, volumeFiles, nil
+}
+
+// planReconstruction compares the input slices of the missing articles with the recovery slices
+// of the recovery volumes without missing articles, only the par2 index files are downloaded
+func planReconstruction(ctx context.Context, candidates []par2Candidate) error {
+	indexFiles, volumeFiles, err := par2Files()
+	if err != nil {
+		return err
+	}
+	set := newPar2Set()
+	for _, file := range indexFiles {
+		if data, err := downloadPar2File(ctx, file); err != nil {
+			return err
+		} else {
+			set.addPackets(data)
+		}
+	}
+	if err := set.complete(); err != nil {
+		return fmt.Errorf("the par2 index files are incomplete: %v", err)
+	}
+
+	// input slices of the missing articles
+	unknown := make(map[string]map[int64]bool)
+	count := 0
+	for _, candidate := range candidates {
+		file := nzbFileByName(candidate.fileName)
+		if file == nil || !set.hasFile(candidate.fileName) {
+			planSetError(candidate.segment.Id, fmt.Errorf("file '%s' is not in the recovery set", candidate.fileName))
+			continue
+		}
+		layout, err := getFileLayout(file, candidate.segment.Number)
+		if err != nil {
+			planSetError(candidate.segment.Id, err)
+			continue
+		}
+		begin, end, err := layout.partRange(candidate.segment.Number)
+		if err != nil {
+			planSetError(candidate.segment.Id, err)
+			continue
+		}
+		if unknown[candidate.fileName] == nil {
+			unknown[candidate.fileName] = make(map[int64]bool)
+		}
+		for slice := (begin - 1) / set.sliceSize; slice <= (end-1)/set.sliceSize; slice++ {
+			if !unknown[candidate.fileName][slice] {
+				unknown[candidate.fileName][slice] = true
+				count++
+			}
+		}
+	}
+
+	// recovery slices of the volumes, as given by their names
+	par2CandidatesLock.Lock()
+	recovery := 0
+	for _, file := range volumeFiles {
+		if !par2Damaged[file.Filename] {
+			if blocks, err := strconv.Atoi(par2VolumeRegexp.FindStringSubmatch(file.Filename)[1]); err == nil {
+				recovery += blocks
+			}
+		}
+	}
+	par2CandidatesLock.Unlock()
+
+	log.Printf("dry run: %v input slices are missing and %v recovery slices are available", count, recovery)
+	for _, candidate := range candidates {
+		if count > recovery {
+			planSetError(candidate.segment.Id, fmt.Errorf("%v input slices are missing, but only %v recovery slices are available", count, recovery))
+		} else {
+			planSetSource(candidate.segment.Id, "par2 files")
+		}
+	}
+	return nil
+}
+
+func reconstructArticles(ctx context.Context, candidates []par2Candidate) error {
+	indexFiles, volumeFiles, err := par2Files()
+	if err != nil {
+		return err
+	}
+
+	// the description of the recovery set is in every par2 file
+	set := newPar2Set()
+	nextVolume := 0
+	addVolume := func(file *nzbparser.NzbFile) error {
+		data, err := downloadPar2File(ctx, file)
+		set.addPackets(data)
+		return err
+	}
+	for _, file := range indexFiles {
+		if err := addVolume(file); err != nil {
+			return err
+		}
+	}
+	for set.complete() != nil && nextVolume < len(volumeFiles) {
+		if err := addVolume(volumeFiles[nextVolume]); err != nil {
+			return err
+		}
+		nextVolume++
+	}
+	if err := set.complete(); err != nil {
+		return err
+	}
+
+	// download all input files of the recovery set
+	tempDir, err := os.MkdirTemp("", "nzbrefresh-par2-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+	missing := make(map[string]map[int]bool)
+	for _, candidate := range candidates {
+		if missing[candidate.fileName] == nil {
+			missing[candidate.fileName] = make(map[int]bool)
+		}
+		missing[candidate.fileName][candidate.segment.Number] = true
+	}
+	inputFiles := make(map[string]*os.File)
+	defer func() {
+		for _, f := range inputFiles {
+			f.Close()
+		}
+	}()
+	var unknown []int // input slices to reconstruct
+	slice := 0
+	buffer := make([]byte, set.sliceSize)
+	for _, id := range set.fileIDs {
+		par2File := set.files[id]
+		file := nzbFileByName(par2File.name)
+		if file == nil {
+			return fmt.Errorf("file '%s' of the recovery set is not in the NZB file", par2File.name)
+		}
+		f, err := os.CreateTemp(tempDir, "input-")
+		if err != nil {
+			return err
+		}
+		inputFiles[par2File.name] = f
+		if err := f.Truncate(par2File.size); err != nil {
+			return err
+		}
+		if err := downloadNzbFile(ctx, file, f, missing[file.Filename]); err != nil {
+			return err
+		}
+		// slices of missing or damaged articles don't match their checksum
+		for n := range par2File.checksum {
+			if err := readSlice(f, buffer, int64(n)*set.sliceSize); err != nil {
+				return err
+			}
+			if md5.Sum(buffer) != par2File.checksum[n] {
+				unknown = append(unknown, slice)
+			}
+			slice++
+		}
+	}
+	log.Printf("%v of %v input slices of the recovery set are missing or damaged", len(unknown), slice)
+
+	if len(unknown) > 0 {
+		for len(set.recovery) < len(unknown) && nextVolume < len(volumeFiles) {
+			if err := addVolume(volumeFiles[nextVolume]); err != nil {
+				return err
+			}
+			nextVolume++
+		}
+		if len(set.recovery) < len(unknown) {
+			return fmt.Errorf("%v input slices are missing, but only %v recovery slices are available", len(unknown), len(set.recovery))
+		}
+		for {
+			err := reconstructSlices(set, inputFiles, unknown)
+			if err == nil {
+				break
+			}
+			// the recovery slices of the next volume may be combined with the ones already downloaded
+			if !errors.Is(err, errPar2Dependent) || nextVolume >= len(volumeFiles) {
+				return err
+			}
+			log.Print(err)
+			if err := addVolume(volumeFiles[nextVolume]); err != nil {
+				return err
+			}
+			nextVolume++
+		}
+	}
+
+	// the reconstructed articles are re-posted under their original message IDs
+	for _, candidate := range candidates {
+		file := nzbFileByName(candidate.fileName)
+		f := inputFiles[candidate.fileName]
+		if file == nil || f == nil {
+			log.Printf("article <%s> cannot be reconstructed, as file '%s' is not in the recovery set", candidate.segment.Id, candidate.fileName)
+			planSetError(candidate.segment.Id, fmt.Errorf("file '%s' is not in the recovery set", candidate.fileName))
+			continue
+		}
+		layout, err := getFileLayout(file, candidate.segment.Number)
+		if err != nil {
+			log.Print(fmt.Errorf("unable to reconstruct article <%s>: %v", candidate.segment.Id, err))
+			planSetError(candidate.segment.Id, err)
+			continue
+		}
+		article, err := encodeArticle(candidate.segment, file, layout, f)
+		if err != nil {
+			log.Print(fmt.Errorf("unable to reconstruct article <%s>: %v", candidate.segment.Id, err))
+			planSetError(candidate.segment.Id, err)
+			continue
+		}
+		log.Printf("re-posting article <%s> reconstructed with the par2 files", candidate.segment.Id)
+		if seedArticle(article, candidate.segment.Id, "par2 files", candidate.missingOn) {
+			reconstructed.Add(1)
+		}
+	}
+	return nil
+}
+
+// reconstructSlices computes the unknown input slices from the known ones and the recovery slices
+// and writes them to the input files
+func reconstructSlices(set *par2Set, inputFiles map[string]*os.File, unknown []int) error {
+	var available []uint32
+	for exponent, recovery := range set.recovery {
+		if int64(len(recovery.data)) != set.sliceSize {
+			log.Printf("recovery slice %v is skipped, as it has %v bytes instead of %v", exponent, len(recovery.data), set.sliceSize)
+			continue
+		}
+		available = append(available, exponent)
+	}
+	sort.Slice(available, func(i, j int) bool { return available[i] < available[j] })
+
+	slices := 0
+	for _, id := range set.fileIDs {
+		slices += len(set.files[id].checksum)
+	}
+	bases := par2Bases(slices)
+	isUnknown := make(map[int]bool)
+	for _, slice := range unknown {
+		isUnknown[slice] = true
+	}
+
+	// any of the recovery slices can be used, as long as their rows of the matrix are linearly independent
+	rows := make([][]uint16, len(available))
+	for i, exponent := range available {
+		rows[i] = make([]uint16, len(unknown))
+		for j, slice := range unknown {
+			rows[i][j] = par2Factor(bases[slice], exponent)
+		}
+	}
+	independent := gfIndependentRows(rows, len(unknown))
+	if len(independent) < len(unknown) {
+		return fmt.Errorf("%w: %v input slices are missing, but only %v of the recovery slices %v are independent", errPar2Dependent, len(unknown), len(independent), available)
+	}
+	exponents := make([]uint32, len(independent))
+	matrix := make([][]uint16, len(independent))
+	for n, i := range independent {
+		exponents[n] = available[i]
+		matrix[n] = rows[i]
+	}
+	if skipped := independent[len(independent)-1] + 1 - len(independent); skipped > 0 {
+		log.Printf("%v of the recovery slices %v are skipped, as they cannot be combined with the others", skipped, available[:independent[len(independent)-1]+1])
+	}
+
+	// the recovery slices minus the contribution of the known input slices leave the sum of the unknown ones
+	sums := make([][]byte, len(exponents))
+	for n, exponent := range exponents {
+		sums[n] = append([]byte(nil), set.recovery[exponent].data...)
+	}
+	buffer := make([]byte, set.sliceSize)
+	slice := 0
+	for _, id := range set.fileIDs {
+		par2File := set.files[id]
+		for n := range par2File.checksum {
+			if !isUnknown[slice] {
+				if err := readSlice(inputFiles[par2File.name], buffer, int64(n)*set.sliceSize); err != nil {
+					return err
+				}
+				for i, exponent := range exponents {
+					gfMulAdd(sums[i], buffer, par2Factor(bases[slice], exponent))
+				}
+			}
+			slice++
+		}
+	}
+
+	inverse, err := gfInvert(matrix)
+	if err != nil {
+		return fmt.Errorf("unable to combine the recovery slices %v: %v", exponents, err)
+	}
+	for j, slice := range unknown {
+		data := make([]byte, set.sliceSize)
+		for i := range exponents {
+			gfMulAdd(data, sums[i], inverse[j][i])
+		}
+		par2File, n := set.sliceFile(slice)
+		if md5.Sum(data) != par2File.checksum[n] {
+			return fmt.Errorf("reconstructed slice %v of file '%s' doesn't match its checksum", n, par2File.name)
+		}
+		offset := int64(n) * set.sliceSize
+		if _, err := inputFiles[par2File.name].WriteAt(data[:min(set.sliceSize, par2File.size-offset)], offset); err != nil {
+			return err
+		}
+	}
+	log.Printf("%v input slices reconstructed with the recovery slices %v", len(unknown), exponents)
+	return nil
+}
+
+// sliceFile returns the file of the input slice and the number of the slice within the file
+func (set *par2Set) sliceFile(slice int) (*par2File, int) {
+	for _, id := range set.fileIDs {
+		if slice < len(set.files[id].checksum) {
+			return set.files[id], slice
+		}
+		slice -= len(set.files[id].checksum)
+	}
+	return nil, 0
+}
+
+// readSlice reads the input slice at the offset, the last slice of a file is padded with zeros
+func readSlice(r io.ReaderAt, buffer []byte, offset int64) error {
+	n, err := r.ReadAt(buffer, offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	clear(buffer[n:])
+	return nil
+}
+
+// hasFile returns true if the file with the name is an input file of the recovery set
+func (set *par2Set) hasFile(name string) bool {
+	for _, id := range set.fileIDs {
+		if set.files[id].name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func nzbFileByName(name string) *nzbparser.NzbFile {
+	for n := range nzbfile.Files {
+		if nzbfile.Files[n].Filename == name {
+			return &nzbfile.Files[n]
+		}
+	}
+	return nil
+}
+
+func nzbFileBytes(file *nzbparser.NzbFile) int64 {
+	var size int64
+	for _, segment := range file.Segments {
+		size += int64(segment.Bytes)
+	}
+	return size
+}
+
+// downloadPar2File downloads a par2 file into memory, the data of missing articles is left empty
+func downloadPar2File(ctx context.Context, file *nzbparser.NzbFile) ([]byte, error) {
+	var data memoryFile
+	err := downloadNzbFile(ctx, file, &data, nil)
+	return data.bytes(), err
+}
+
+// downloadNzbFile downloads and decodes the articles of the file, except the ones to skip, and writes them to w.
+// It stops and returns the error of the context if the context is cancelled.
+func downloadNzbFile(ctx context.Context, file *nzbparser.NzbFile, w io.WriterAt, skip map[int]bool) error {
+	providers := make([]*Provider, 0, len(providerList))
+	for n := range providerList {
+		providers = append(providers, &providerList[n])
+	}
+	log.Printf("downloading file '%s' for the reconstruction with the par2 files", file.Filename)
+	segmentChan := make(chan nzbparser.NzbSegment)
+	var wg sync.WaitGroup
+	for i := uint32(0); i < maxConns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for segment := range segmentChan {
+				if ctx.Err() != nil {
+					continue
+				}
+				article, err := loadArticle(providers, segment, file.Subject)
+				if err != nil {
+					log.Print(err)
+					continue
+				}
+				body, err := io.ReadAll(article.Body)
+				if err != nil {
+					log.Print(err)
+					continue
+				}
+				yenc, err := decodeYenc(body)
+				if err != nil {
+					log.Print(fmt.Errorf("unable to decode article <%s>: %v", segment.Id, err))
+					continue
+				}
+				if _, err := w.WriteAt(yenc.data, max(yenc.begin, 1)-1); err != nil {
+					log.Print(fmt.Errorf("unable to write article <%s>: %v", segment.Id, err))
+				}
+			}
+		}()
+	}
+segments:
+	for _, segment := range file.Segments {
+		if !skip[segment.Number] {
+			select {
+			case segmentChan <- segment:
+			case <-ctx.Done():
+				break segments
+			}
+		}
+	}
+	close(segmentChan)
+	wg.Wait()
+	return ctx.Err()
+}
+
+// file in memory written at arbitrary offsets
+type memoryFile struct {
+	data []byte
+	lock sync.Mutex
+}
+
+func (m *memoryFile) WriteAt(p []byte, offset int64) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if end := int(offset) + len(p); end > len(m.data) {
+		m.data = append(m.data, make([]byte, end-len(m.data))...)
+	}
+	copy(m.data[offset:], p)
+	return len(p), nil
+}
+
+func (m *memoryFile) bytes() []byte {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.data
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Tensai75/nntp"
+	"github.com/Tensai75/nzbparser"
+)
+
+var (
+	newMessageIDs     = make(map[string]string) // articles re-posted under a new message ID in the current run (old -> new)
+	newMessageIDsLock sync.Mutex
+)
+
+func resetNewMessageIDs() {
+	newMessageIDsLock.Lock()
+	defer newMessageIDsLock.Unlock()
+	newMessageIDs = make(map[string]string)
+}
+
+// newMessageID generates a random message ID with the same domain as the original message ID
+func newMessageID(messageID string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	domain := "nzbrefresh"
+	if _, right, ok := strings.Cut(messageID, "@"); ok && right != "" {
+		domain = right
+	}
+	return hex.EncodeToString(random) + "@" + domain, nil
+}
+
+// repostArticle posts the article under a new message ID.
+// As the new article is missing on all providers, it is posted to one provider of each backbone.
+func repostArticle(article *nntp.Article, segmentID string) error {
+	newID, err := newMessageID(segmentID)
+	if err != nil {
+		return fmt.Errorf("unable to generate a new message ID for article <%s>: %v", segmentID, err)
+	}
+	article.Header["Message-Id"] = []string{"<" + newID + ">"}
+	providers := make([]*Provider, 0, len(providerList))
+	for n := range providerList {
+		providers = append(providers, &providerList[n])
+	}
+	posted := false
+	for _, targets := range uploadTargets(providers) {
+		if _, err := reuploadArticle(targets, article, segmentID); err != nil {
+			log.Print(err)
+		} else {
+			posted = true
+		}
+	}
+	if !posted {
+		return fmt.Errorf("unable to re-post article <%s> as <%s> to any provider", segmentID, newID)
+	}
+	log.Printf("article <%s> re-posted as <%s>", segmentID, newID)
+	newMessageIDsLock.Lock()
+	newMessageIDs[segmentID] = newID
+	newMessageIDsLock.Unlock()
+	return nil
+}
+
+// writeRepostNzbFile writes a copy of the NZB file where the re-posted segments point to their new message IDs
+func writeRepostNzbFile(path string) error {
+	if !args.NewMessageIDs {
+		return nil
+	}
+	newMessageIDsLock.Lock()
+	defer newMessageIDsLock.Unlock()
+	if len(newMessageIDs) == 0 {
+		fmt.Println("No articles re-posted under a new message ID, no new NZB file written")
+		log.Print("no articles re-posted under a new message ID, no new NZB file written")
+		return nil
+	}
+	nzb := *nzbfile
+	nzb.Files = make(nzbparser.NzbFiles, len(nzbfile.Files))
+	for n, file := range nzbfile.Files {
+		// the segments are copied, as the loaded NZB file must stay unchanged
+		file.Segments = append(nzbparser.NzbSegments(nil), file.Segments...)
+		for i, segment := range file.Segments {
+			if newID, ok := newMessageIDs[segment.Id]; ok {
+				file.Segments[i].Id = newID
+			}
+		}
+		nzb.Files[n] = file
+	}
+	nzbFileName := args.NzbOut
+	if nzbFileName == "" {
+		nzbFileName = strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".gz"), ".nzb") + ".refreshed.nzb"
+	}
+	if file, err := nzbparser.Write(&nzb); err != nil {
+		return fmt.Errorf("unable to create the new NZB file: %v", err)
+	} else if err := os.WriteFile(nzbFileName, file, 0644); err != nil {
+		return fmt.Errorf("unable to write the new NZB file '%s': %v", nzbFileName, err)
+	}
+	result := fmt.Sprintf("%v articles re-posted under a new message ID, new NZB file written to '%s'", len(newMessageIDs), nzbFileName)
+	fmt.Println(result)
+	log.Print(result)
+	return nil
+}
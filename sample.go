@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Tensai75/nzbparser"
+)
+
+// z value of the 95% confidence intervals of the sampling estimates
+const confidenceZ = 1.96
+
+// size of the sample of segments checked per file
+type sampleSize struct {
+	count   int     // number of segments
+	percent float64 // or percentage of the segments
+}
+
+var sampling *sampleSize // nil if all segments are checked
+
+// parseSample parses the --sample argument, either a number of segments (e.g. "50") or a percentage (e.g. "10%")
+func parseSample(value string) (*sampleSize, error) {
+	if percent, ok := strings.CutSuffix(strings.TrimSpace(value), "%"); ok {
+		if p, err := strconv.ParseFloat(percent, 64); err != nil || p <= 0 || p > 100 {
+			return nil, fmt.Errorf("invalid sample size '%s', the percentage must be greater than 0 and at most 100", value)
+		} else {
+			return &sampleSize{percent: p}, nil
+		}
+	}
+	if count, err := strconv.Atoi(strings.TrimSpace(value)); err != nil || count <= 0 {
+		return nil, fmt.Errorf("invalid sample size '%s', must be a number of segments (e.g. '50') or a percentage (e.g. '10%%')", value)
+	} else {
+		return &sampleSize{count: count}, nil
+	}
+}
+
+// segmentsToCheck returns the segments of the file to check, which is a sample of the segments in sampling mode
+func segmentsToCheck(file nzbparser.NzbFile) []nzbparser.NzbSegment {
+	if sampling == nil {
+		return file.Segments
+	}
+	return sampleSegments(file.Segments, sampling)
+}
+
+// countSegmentsToCheck returns the number of segments of the NZB file to check
+func countSegmentsToCheck(nzb *nzbparser.Nzb) int {
+	total := 0
+	for _, file := range nzb.Files {
+		if sampling == nil {
+			total += len(file.Segments)
+		} else {
+			total += sampleCount(len(file.Segments), sampling)
+		}
+	}
+	return total
+}
+
+// sampleCount returns the number of segments of a sample of the segments
+func sampleCount(segments int, size *sampleSize) int {
+	count := size.count
+	if size.percent > 0 {
+		count = int(math.Ceil(float64(segments) * size.percent / 100))
+	}
+	return min(count, segments)
+}
+
+// sampleSegments returns a stratified random sample of the segments, which always includes the first and the last segment.
+// The other segments are split into equal strata and one segment is randomly picked of each stratum.
+func sampleSegments(segments []nzbparser.NzbSegment, size *sampleSize) []nzbparser.NzbSegment {
+	count := sampleCount(len(segments), size)
+	if count >= len(segments) {
+		return segments
+	}
+	sorted := make([]nzbparser.NzbSegment, len(segments))
+	copy(sorted, segments)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Number < sorted[j].Number })
+
+	sample := []nzbparser.NzbSegment{sorted[0]}
+	inner := sorted[1 : len(sorted)-1]
+	strata := count - 2
+	for i := 0; i < strata; i++ {
+		start, end := i*len(inner)/strata, (i+1)*len(inner)/strata
+		sample = append(sample, inner[start+rand.Intn(end-start)])
+	}
+	if len(sorted) > 1 && count > 1 {
+		sample = append(sample, sorted[len(sorted)-1])
+	}
+	return sample
+}
+
+// confidenceInterval returns the Wilson score interval of the share of the available articles
+// with finite population correction, as the sample is drawn without replacement from the segments of the NZB file
+func confidenceInterval(available, checked, population uint64) (float64, float64) {
+	if checked == 0 {
+		return 0, 1
+	}
+	n := float64(checked)
+	p := float64(available) / n
+	z2 := confidenceZ * confidenceZ
+	if population > 1 && population >= checked {
+		z2 *= float64(population-checked) / float64(population-1)
+	}
+	denominator := 1 + z2/n
+	center := (p + z2/(2*n)) / denominator
+	margin := math.Sqrt(z2*p*(1-p)/n+z2*z2/(4*n*n)) / denominator
+	return math.Max(0, center-margin), math.Min(1, center+margin)
+}
+
+// sampleResults returns the estimated completeness of each provider and the recommendation
+// whether a full check and refresh of the NZB file is warranted
+func sampleResults() []string {
+	var results []string
+	population := uint64(nzbfile.Segments)
+	var missing []string
+	lowest := 1.0
+	for n := range providerList {
+		provider := &providerList[n]
+		checked := provider.articles.checked.Load()
+		if checked == 0 {
+			// e.g. offline or not asked because of another provider of the same backbone
+			continue
+		}
+		available := provider.articles.available.Load()
+		low, high := confidenceInterval(available, checked, population)
+		results = append(results, fmt.Sprintf("Estimated completeness of '%s': %.2f%% (95%% confidence interval: %.2f%% - %.2f%%, %v segments sampled)",
+			provider.Name, float64(available)/float64(checked)*100, low*100, high*100, checked))
+		if available < checked {
+			missing = append(missing, fmt.Sprintf("'%s'", provider.Name))
+		}
+		lowest = math.Min(lowest, low)
+	}
+	switch {
+	case runProgress.unrecoverable.Load() > 0:
+		results = append(results, fmt.Sprintf("Recommendation: %v sampled articles are missing on all providers, the NZB file is probably incomplete and cannot be repaired by a refresh",
+			runProgress.unrecoverable.Load()))
+	case len(missing) > 0:
+		results = append(results, fmt.Sprintf("Recommendation: articles are missing on %s, a full check and refresh is warranted", strings.Join(missing, ", ")))
+	case len(results) > 0:
+		results = append(results, fmt.Sprintf("Recommendation: no missing articles found, a full check is not needed (completeness of at least %.2f%% on all providers with 95%% confidence)", lowest*100))
+	default:
+		results = append(results, "Recommendation: no segments could be checked, a full check is warranted")
+	}
+	return results
+}
+
+func logSampleResults() {
+	if sampling == nil {
+		return
+	}
+	for _, result := range sampleResults() {
+		fmt.Println(result)
+		log.Print(result)
+	}
+}
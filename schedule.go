@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// schedule configuration as loaded from the schedule JSON file
+	Schedule struct {
+		Stagger uint32 // seconds to wait between two consecutive NZB files
+		Jobs    []ScheduleJob
+	}
+
+	ScheduleJob struct {
+		Name       string
+		Paths      []string // NZB files or directories containing NZB files
+		Cron       string   // cron expression (minute hour day-of-month month day-of-week)
+		Interval   string   // interval as duration string (e.g. "12h"), used if Cron is empty
+		CheckOnly  bool
+		RunAtStart bool
+
+		cron     *cronExpression
+		interval time.Duration
+		queued   bool
+	}
+
+	// parsed cron expression, one set of allowed values per field
+	cronExpression struct {
+		minute, hour, dom, month, dow map[int]bool
+		domAny, dowAny                bool
+	}
+)
+
+var (
+	scheduleQueue     chan *ScheduleJob
+	scheduleQueueLock sync.Mutex
+)
+
+func runSchedule() {
+	setupLogging(scheduleArgs.Schedule, scheduleArgs.Debug)
+
+	schedule, err := loadSchedule(scheduleArgs.Schedule)
+	if err != nil {
+		exit(fmt.Errorf("unable to load schedule: %v", err))
+	}
+
+	// the settings of the schedule subcommand apply to all runs
+	args.Provider = scheduleArgs.Provider
+	args.Csv = scheduleArgs.Csv
+	args.History = scheduleArgs.History
+	args.NoHistory = scheduleArgs.NoHistory
+	args.Topology = scheduleArgs.Topology
+	args.AbortThreshold = scheduleArgs.AbortThreshold
+	args.NotifyArgs = scheduleArgs.NotifyArgs
+
+	log.Print("preparing...")
+	preparationStartTime = time.Now()
+	setupProviders(args.Provider)
+	log.Printf("preparation took %v", time.Since(preparationStartTime))
+
+	if scheduleArgs.Metrics != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/metrics", handleMetrics)
+			log.Printf("metrics available on %s/metrics", scheduleArgs.Metrics)
+			if err := http.ListenAndServe(scheduleArgs.Metrics, mux); err != nil {
+				exit(fmt.Errorf("unable to start metrics server: %v", err))
+			}
+		}()
+	}
+
+	// jobs are executed one after another so they share the connection limits of the providers
+	scheduleQueue = make(chan *ScheduleJob, len(schedule.Jobs))
+	for n := range schedule.Jobs {
+		go scheduleJob(&schedule.Jobs[n])
+	}
+	fmt.Printf("Scheduler started with %v jobs\n", len(schedule.Jobs))
+	for job := range scheduleQueue {
+		runScheduleJob(job, time.Duration(schedule.Stagger)*time.Second)
+		scheduleQueueLock.Lock()
+		job.queued = false
+		scheduleQueueLock.Unlock()
+	}
+}
+
+func loadSchedule(path string) (*Schedule, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	schedule := Schedule{Stagger: 10}
+	if err := json.Unmarshal(file, &schedule); err != nil {
+		return nil, err
+	}
+	if len(schedule.Jobs) == 0 {
+		return nil, fmt.Errorf("no jobs defined")
+	}
+	for n := range schedule.Jobs {
+		job := &schedule.Jobs[n]
+		if job.Name == "" {
+			job.Name = fmt.Sprintf("Job %v", n+1)
+		}
+		if len(job.Paths) == 0 {
+			return nil, fmt.Errorf("no paths defined for job '%s'", job.Name)
+		}
+		if job.Cron != "" {
+			if job.cron, err = parseCron(job.Cron); err != nil {
+				return nil, fmt.Errorf("invalid cron expression for job '%s': %v", job.Name, err)
+			}
+		} else if job.Interval != "" {
+			if job.interval, err = time.ParseDuration(job.Interval); err != nil {
+				return nil, fmt.Errorf("invalid interval for job '%s': %v", job.Name, err)
+			}
+			if job.interval < time.Minute {
+				return nil, fmt.Errorf("interval for job '%s' must be at least 1m", job.Name)
+			}
+		} else {
+			return nil, fmt.Errorf("neither cron expression nor interval defined for job '%s'", job.Name)
+		}
+	}
+	return &schedule, nil
+}
+
+// scheduleJob queues the job each time it is due
+func scheduleJob(job *ScheduleJob) {
+	if job.RunAtStart {
+		queueScheduleJob(job)
+	}
+	for {
+		next := job.next(time.Now())
+		log.Printf("next run of job '%s' scheduled for %v", job.Name, next.Format(time.RFC1123))
+		time.Sleep(time.Until(next))
+		queueScheduleJob(job)
+	}
+}
+
+func queueScheduleJob(job *ScheduleJob) {
+	scheduleQueueLock.Lock()
+	defer scheduleQueueLock.Unlock()
+	if job.queued {
+		// the previous run of this job has not finished yet
+		log.Printf("skipping run of job '%s' as the previous run is still pending", job.Name)
+		return
+	}
+	job.queued = true
+	scheduleQueue <- job
+}
+
+func (job *ScheduleJob) next(from time.Time) time.Time {
+	if job.cron != nil {
+		return job.cron.next(from)
+	}
+	return from.Add(job.interval)
+}
+
+func runScheduleJob(job *ScheduleJob, stagger time.Duration) {
+	files := job.nzbFiles()
+	log.Printf("starting job '%s' with %v NZB files", job.Name, len(files))
+	fmt.Printf("%v: Starting job '%s' with %v NZB files\n", time.Now().Format(time.RFC1123), job.Name, len(files))
+	for n, path := range files {
+		if n > 0 && stagger > 0 {
+			time.Sleep(stagger)
+		}
+		fmt.Printf("Processing '%s'\n", path)
+		log.Printf("processing '%s'", path)
+		preparationStartTime = time.Now()
+		if nzbfile, err = loadNzbFile(path); err != nil {
+			fmt.Printf("Unable to load NZB file '%s': %v\n", path, err)
+			log.Print(fmt.Errorf("unable to load NZB file '%s': %v", path, err))
+			continue
+		}
+		args.NZBFile = path
+		args.CheckOnly = job.CheckOnly
+		if err := checkNzbFile(context.Background(), path); err != nil {
+			fmt.Printf("Error processing '%s': %v\n", path, err)
+			log.Print(fmt.Errorf("error processing '%s': %v", path, err))
+		}
+	}
+	log.Printf("job '%s' finished", job.Name)
+}
+
+// nzbFiles returns all NZB files of the job, directories are searched recursively
+func (job *ScheduleJob) nzbFiles() []string {
+	var files []string
+	for _, path := range job.Paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Print(fmt.Errorf("unable to access path '%s' of job '%s': %v", path, job.Name, err))
+			continue
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		filepath.WalkDir(path, func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				log.Print(fmt.Errorf("unable to access path '%s' of job '%s': %v", filePath, job.Name, err))
+				return nil
+			}
+			if !d.IsDir() && strings.EqualFold(filepath.Ext(filePath), ".nzb") {
+				files = append(files, filePath)
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+// parseCron parses a standard 5 field cron expression.
+// Supported are '*', single values, ranges ('1-5'), lists ('1,3,5') and steps ('*/15', '0-30/10').
+func parseCron(expression string) (*cronExpression, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields but got %v", len(fields))
+	}
+	var err error
+	cron := new(cronExpression)
+	if cron.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if cron.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if cron.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if cron.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	if cron.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+	// 7 is an alias for sunday
+	if cron.dow[7] {
+		cron.dow[0] = true
+	}
+	cron.domAny = fields[2] == "*"
+	cron.dowAny = fields[4] == "*"
+	return cron, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rangePart, stepPart, found := strings.Cut(part, "/"); found {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step '%s'", stepPart)
+			}
+			part = rangePart
+		}
+		start, end := min, max
+		if part != "*" {
+			if from, to, found := strings.Cut(part, "-"); found {
+				var err1, err2 error
+				start, err1 = strconv.Atoi(from)
+				end, err2 = strconv.Atoi(to)
+				if err1 != nil || err2 != nil {
+					return nil, fmt.Errorf("invalid range '%s'", part)
+				}
+			} else {
+				value, err := strconv.Atoi(part)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value '%s'", part)
+				}
+				start = value
+				if step == 1 {
+					end = value
+				}
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("value out of range '%s'", part)
+		}
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// next returns the next time after from matching the cron expression
+func (cron *cronExpression) next(from time.Time) time.Time {
+	t := from.Truncate(time.Minute).Add(time.Minute)
+	// a matching time is found within at most 4 years (february 29th)
+	for limit := t.AddDate(4, 0, 1); t.Before(limit); {
+		if !cron.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !cron.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !cron.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !cron.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return from.AddDate(100, 0, 0)
+}
+
+// matchesDay follows the cron convention: if both day of month and day of week
+// are restricted, a day matches if either of them matches
+func (cron *cronExpression) matchesDay(t time.Time) bool {
+	dom := cron.dom[t.Day()]
+	dow := cron.dow[int(t.Weekday())]
+	switch {
+	case cron.domAny && cron.dowAny:
+		return true
+	case cron.domAny:
+		return dow
+	case cron.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
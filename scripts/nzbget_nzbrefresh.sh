@@ -0,0 +1,49 @@
+#!/bin/sh
+#
+##############################################################################
+### NZBGET POST-PROCESSING SCRIPT                                          ###
+### NZBGET QUEUE SCRIPT                                                    ###
+### QUEUE EVENTS: NZB_ADDED
+
+# Refreshes the articles of the NZB file with nzbrefresh.
+#
+# As post-processing script the NZB file is refreshed after the download,
+# as queue script it is refreshed as soon as it is added to the queue.
+#
+# If no provider config file is set and there is none next to the
+# executable, the news servers configured in NZBGet are used.
+
+##############################################################################
+### OPTIONS                                                                ###
+
+# Path to the nzbrefresh executable.
+#Executable=/usr/local/bin/nzbrefresh
+
+# Path to the provider JSON config file.
+#
+# If empty, the provider.json next to the executable is used.
+#Provider=
+
+# Path to the config file with providers and settings (JSON, YAML or TOML).
+#
+# The settings of the config file apply to the options not set here.
+#Config=
+
+# Only check availability - don't re-upload (yes, no).
+#CheckOnly=no
+
+# Only refresh failed downloads (yes, no).
+#
+# Only applies to the post-processing script.
+#OnlyFailed=yes
+
+# Path to the history database file.
+#History=
+
+# Write a debug log file (yes, no).
+#Debug=no
+
+### NZBGET POST-PROCESSING SCRIPT                                          ###
+##############################################################################
+
+exec "$NZBPO_EXECUTABLE"
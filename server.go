@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// a job submitted to the API server
+	serverJob struct {
+		ID        string         `json:"id"`
+		Name      string         `json:"name"`
+		Path      string         `json:"path"`
+		CheckOnly bool           `json:"checkOnly"`
+		Status    string         `json:"status"`
+		Error     string         `json:"error,omitempty"`
+		Created   time.Time      `json:"created"`
+		Started   *time.Time     `json:"started,omitempty"`
+		Finished  *time.Time     `json:"finished,omitempty"`
+		Report    *historyRecord `json:"-"`
+
+		segments int // number of segments to check, taken when the job starts
+		cancel   context.CancelFunc
+	}
+
+	// job status as returned by the API including the live progress
+	serverJobStatus struct {
+		*serverJob
+		Progress  *serverJobProgress `json:"progress,omitempty"`
+		Providers []serverProvider   `json:"providers,omitempty"`
+	}
+	serverJobProgress struct {
+		Segments        int    `json:"segments"`
+		SegmentsChecked uint64 `json:"segmentsChecked"`
+		Uploads         uint64 `json:"uploads"`
+		UploadsDone     uint64 `json:"uploadsDone"`
+	}
+	serverProvider struct {
+		historyProvider
+		Connections uint32 `json:"connections,omitempty"`
+	}
+
+	// request body for job submission by path
+	serverJobRequest struct {
+		Path      string `json:"path"`
+		CheckOnly bool   `json:"checkOnly"`
+	}
+)
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+var (
+	serverJobs     = make(map[string]*serverJob)
+	serverJobList  []*serverJob
+	serverJobsLock sync.Mutex
+	serverQueue    = make(chan *serverJob, 1024)
+	runningJob     *serverJob
+)
+
+func runServer() {
+	setupLogging("serve", serveArgs.Debug)
+
+	// the settings of the serve subcommand apply to all runs
+	args.Provider = serveArgs.Provider
+	args.History = serveArgs.History
+	args.NoHistory = serveArgs.NoHistory
+	args.Topology = serveArgs.Topology
+	args.AbortThreshold = serveArgs.AbortThreshold
+	args.NotifyArgs = serveArgs.NotifyArgs
+
+	if err := os.MkdirAll(serveArgs.UploadDir, 0755); err != nil {
+		exit(fmt.Errorf("unable to create upload directory: %v", err))
+	}
+
+	log.Print("preparing...")
+	preparationStartTime = time.Now()
+	setupProviders(args.Provider)
+	log.Printf("preparation took %v", time.Since(preparationStartTime))
+
+	go processServerJobs()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/jobs", serverAuth(handleJobs))
+	mux.HandleFunc("/api/jobs/", serverAuth(handleJob))
+	mux.HandleFunc("/api/history", serverAuth(handleHistory))
+	mux.HandleFunc("/metrics", serverAuth(handleMetrics))
+	mux.Handle("/", dashboardHandler())
+	fmt.Printf("API server listening on %s\n", serveArgs.Listen)
+	log.Printf("API server listening on %s", serveArgs.Listen)
+	if err := http.ListenAndServe(serveArgs.Listen, mux); err != nil {
+		exit(fmt.Errorf("unable to start API server: %v", err))
+	}
+}
+
+// processServerJobs runs the queued jobs one after another
+func processServerJobs() {
+	for job := range serverQueue {
+		serverJobsLock.Lock()
+		if job.Status != jobQueued {
+			// job was cancelled while queued
+			serverJobsLock.Unlock()
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		job.cancel = cancel
+		job.Status = jobRunning
+		started := time.Now()
+		job.Started = &started
+		serverJobsLock.Unlock()
+
+		log.Printf("starting job %s for '%s'", job.ID, job.Path)
+		err := runServerJob(ctx, job)
+
+		serverJobsLock.Lock()
+		finished := time.Now()
+		job.Finished = &finished
+		switch {
+		case errors.Is(err, errRunCancelled):
+			job.Status = jobCancelled
+		case err != nil:
+			job.Status = jobFailed
+			job.Error = err.Error()
+		default:
+			job.Status = jobDone
+		}
+		runningJob = nil
+		serverJobsLock.Unlock()
+		cancel()
+		log.Printf("job %s %s", job.ID, job.Status)
+	}
+}
+
+func runServerJob(ctx context.Context, job *serverJob) error {
+	preparationStartTime = time.Now()
+	if nzbfile, err = loadNzbFile(job.Path); err != nil {
+		return fmt.Errorf("unable to load NZB file '%s': %v", job.Path, err)
+	}
+	args.NZBFile = job.Path
+	args.CheckOnly = job.CheckOnly
+	serverJobsLock.Lock()
+	runningJob = job
+	job.segments = countSegmentsToCheck(nzbfile)
+	serverJobsLock.Unlock()
+	if err := checkNzbFile(ctx, job.Path); err != nil {
+		return err
+	}
+	report := runReport(job.Path)
+	serverJobsLock.Lock()
+	job.Report = &report
+	serverJobsLock.Unlock()
+	return nil
+}
+
+func serverAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if serveArgs.Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+serveArgs.Token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, fmt.Errorf("invalid or missing token"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// isLoopbackAddress returns true if the listen address only accepts connections from the local host
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleJobs handles GET /api/jobs (list jobs) and POST /api/jobs (submit job)
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		serverJobsLock.Lock()
+		jobs := make([]serverJobStatus, 0, len(serverJobList))
+		for _, job := range serverJobList {
+			jobs = append(jobs, jobStatus(job))
+		}
+		serverJobsLock.Unlock()
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		job, err := newServerJob(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		serverJobsLock.Lock()
+		defer serverJobsLock.Unlock()
+		select {
+		case serverQueue <- job:
+		default:
+			writeJSONError(w, http.StatusServiceUnavailable, fmt.Errorf("job queue is full"))
+			return
+		}
+		serverJobs[job.ID] = job
+		serverJobList = append(serverJobList, job)
+		log.Printf("job %s queued for '%s'", job.ID, job.Path)
+		writeJSON(w, http.StatusCreated, jobStatus(job))
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+	}
+}
+
+// handleJob handles GET /api/jobs/{id}, GET /api/jobs/{id}/report, GET /api/jobs/{id}/files and DELETE /api/jobs/{id} (cancel job)
+func handleJob(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	serverJobsLock.Lock()
+	defer serverJobsLock.Unlock()
+	job, ok := serverJobs[id]
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("job '%s' not found", id))
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, jobStatus(job))
+	case action == "report" && r.Method == http.MethodGet:
+		if job.Report == nil {
+			writeJSONError(w, http.StatusConflict, fmt.Errorf("job '%s' has no report (status: %s)", id, job.Status))
+			return
+		}
+		writeJSON(w, http.StatusOK, job.Report)
+	case action == "files" && r.Method == http.MethodGet:
+		switch {
+		case job == runningJob:
+			writeJSON(w, http.StatusOK, runReport(job.Path).Files)
+		case job.Report != nil:
+			writeJSON(w, http.StatusOK, job.Report.Files)
+		default:
+			writeJSON(w, http.StatusOK, []historyFile{})
+		}
+	case (action == "" && r.Method == http.MethodDelete) || (action == "cancel" && r.Method == http.MethodPost):
+		switch job.Status {
+		case jobQueued:
+			job.Status = jobCancelled
+			finished := time.Now()
+			job.Finished = &finished
+		case jobRunning:
+			job.cancel()
+		default:
+			writeJSONError(w, http.StatusConflict, fmt.Errorf("job '%s' is already %s", id, job.Status))
+			return
+		}
+		log.Printf("job %s cancelled", job.ID)
+		writeJSON(w, http.StatusOK, jobStatus(job))
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+	}
+}
+
+// handleHistory handles GET /api/history (optionally filtered by the NZB identity given as query parameter "nzbId")
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	records, err := loadHistory(args.History, r.URL.Query().Get("nzbId"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("unable to load the history database: %v", err))
+		return
+	}
+	// the per file statistics are only returned for a single NZB
+	if r.URL.Query().Get("nzbId") == "" {
+		for n := range records {
+			records[n].Files = nil
+		}
+	}
+	if records == nil {
+		records = []historyRecord{}
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+// newServerJob creates a job either from an uploaded NZB file (multipart form field "nzb")
+// or from a JSON body referencing an NZB file on the server
+func newServerJob(r *http.Request) (*serverJob, error) {
+	job := &serverJob{
+		ID:      newJobID(),
+		Status:  jobQueued,
+		Created: time.Now(),
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("nzb")
+		if err != nil {
+			return nil, fmt.Errorf("unable to read uploaded NZB file: %v", err)
+		}
+		defer file.Close()
+		job.Name = filepath.Base(header.Filename)
+		job.CheckOnly = r.FormValue("checkOnly") == "true"
+		dir := filepath.Join(serveArgs.UploadDir, job.ID)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("unable to store uploaded NZB file: %v", err)
+		}
+		job.Path = filepath.Join(dir, job.Name)
+		f, err := os.Create(job.Path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to store uploaded NZB file: %v", err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(f, file); err != nil {
+			return nil, fmt.Errorf("unable to store uploaded NZB file: %v", err)
+		}
+	} else {
+		var request serverJobRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			return nil, fmt.Errorf("invalid request: %v", err)
+		}
+		if request.Path == "" {
+			return nil, fmt.Errorf("no path to NZB file provided")
+		}
+		if _, err := os.Stat(request.Path); err != nil {
+			return nil, fmt.Errorf("unable to access NZB file: %v", err)
+		}
+		job.Name = filepath.Base(request.Path)
+		job.Path = request.Path
+		job.CheckOnly = request.CheckOnly
+	}
+	return job, nil
+}
+
+// jobStatus returns the status of the job, the caller must hold serverJobsLock
+func jobStatus(job *serverJob) serverJobStatus {
+	status := serverJobStatus{serverJob: job}
+	switch {
+	case job == runningJob:
+		status.Progress = &serverJobProgress{
+			Segments:        job.segments,
+			SegmentsChecked: runProgress.segmentsChecked.Load(),
+			Uploads:         runProgress.uploadsTotal.Load(),
+			UploadsDone:     runProgress.uploadsDone.Load(),
+		}
+		for n := range providerList {
+			status.Providers = append(status.Providers, serverProvider{
+				historyProvider: historyProvider{
+					Name:      providerList[n].Name,
+					Checked:   providerList[n].articles.checked.Load(),
+					Available: providerList[n].articles.available.Load(),
+					Missing:   providerList[n].articles.missing.Load(),
+					Refreshed: providerList[n].articles.refreshed.Load(),
+					Offline:   providerList[n].articles.offline.Load(),
+				},
+				Connections: providerList[n].connectionsUsed(),
+			})
+		}
+	case job.Report != nil:
+		for _, provider := range job.Report.Providers {
+			status.Providers = append(status.Providers, serverProvider{historyProvider: provider})
+		}
+	}
+	sort.Slice(status.Providers, func(i, j int) bool { return status.Providers[i].Name < status.Providers[j].Name })
+	return status
+}
+
+func newJobID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Print(fmt.Errorf("unable to write API response: %v", err))
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
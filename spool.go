@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Tensai75/cmpb"
+	"github.com/Tensai75/nntp"
+)
+
+// name of the file in the spool directory recording the results of the uploads
+const spoolResultsName = "posted.jsonl"
+
+// result of the upload of an article from the spool directory
+type spoolResult struct {
+	MessageID string    `json:"messageId"`
+	PostedTo  []string  `json:"postedTo,omitempty"`  // providers the article was uploaded to
+	Available []string  `json:"available,omitempty"` // providers already having the article
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// offer the articles with IHAVE instead of POST to the providers supporting it (post-spool --ihave)
+var useIHave bool
+
+var spoolResultsLock sync.Mutex
+
+func runPostSpool() {
+	setupLogging(filepath.Base(spoolArgs.Spool), spoolArgs.Debug)
+
+	// the settings of the post-spool subcommand apply to the uploads
+	args.Provider = spoolArgs.Provider
+	args.Topology = spoolArgs.Topology
+	useIHave = spoolArgs.IHave
+
+	entries, err := readSpoolIndex(spoolArgs.Spool)
+	if err != nil {
+		exit(err)
+	}
+	done, err := readSpoolResults(spoolArgs.Spool)
+	if err != nil {
+		exit(err)
+	}
+	// articles uploaded by an earlier run are skipped, so an interrupted upload can be completed later
+	var pending []spoolEntry
+	for _, entry := range entries {
+		if !done[entry.MessageID] {
+			pending = append(pending, entry)
+		}
+	}
+	fmt.Printf("Spool directory '%s': %v articles, %v already uploaded\n", spoolArgs.Spool, len(entries), len(entries)-len(pending))
+	log.Printf("spool directory '%s': %v articles, %v already uploaded", spoolArgs.Spool, len(entries), len(entries)-len(pending))
+	if len(pending) == 0 {
+		return
+	}
+
+	log.Print("preparing...")
+	preparationStartTime = time.Now()
+	setupProviders(args.Provider)
+	resetPostedGroups()
+	log.Printf("preparation took %v", time.Since(preparationStartTime))
+
+	var posted, available, failed atomic.Uint64
+	progressBars = cmpb.NewWithParam(&progressBarsParam)
+	bar := progressBars.NewBar("Posting articles", len(pending))
+	bar.SetPreBar(cmpb.CalcSteps)
+	bar.SetPostBar(cmpb.CalcTime)
+	progressBars.Start()
+
+	entryChan := make(chan spoolEntry)
+	var wg sync.WaitGroup
+	for i := uint32(0); i < maxConns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range entryChan {
+				result := postSpoolArticle(entry)
+				switch {
+				case result.Error != "":
+					failed.Add(1)
+				case len(result.PostedTo) > 0:
+					posted.Add(1)
+				default:
+					available.Add(1)
+				}
+				if err := writeSpoolResult(spoolArgs.Spool, result); err != nil {
+					log.Print(err)
+				}
+				bar.Increment()
+			}
+		}()
+	}
+	for _, entry := range pending {
+		entryChan <- entry
+	}
+	close(entryChan)
+	wg.Wait()
+	bar.SetMessage("done")
+	progressBars.Wait()
+	closeIHaveConns()
+	closePools()
+
+	for n := range providerList {
+		result := fmt.Sprintf("Results for '%s': posted: %v", providerList[n].Name, providerList[n].articles.refreshed.Load())
+		if providerList[n].articles.offline.Load() || providerList[n].isOffline() {
+			result += fmt.Sprintf(" | offline: %v", providerList[n].offlineError())
+		}
+		fmt.Println(result)
+		log.Print(result)
+	}
+	for _, result := range postedGroupsResults() {
+		fmt.Println(result)
+		log.Print(result)
+	}
+	result := fmt.Sprintf("%v articles posted | %v already available | %v failed (results in '%s')", posted.Load(), available.Load(), failed.Load(), filepath.Join(spoolArgs.Spool, spoolResultsName))
+	fmt.Println(result)
+	log.Print(result)
+	if failed.Load() > 0 {
+		exit(fmt.Errorf("%v articles could not be uploaded, run the command again to retry them", failed.Load()))
+	}
+}
+
+// postSpoolArticle uploads an article of the spool directory to the providers still missing it
+func postSpoolArticle(entry spoolEntry) (result spoolResult) {
+	result.MessageID = entry.MessageID
+	defer func() {
+		result.Time = time.Now()
+		if result.Error != "" {
+			log.Print(result.Error)
+		}
+	}()
+	article, err := readSpoolArticle(filepath.Join(spoolArgs.Spool, entry.Path))
+	if err != nil {
+		result.Error = fmt.Sprintf("unable to read article <%s>: %v", entry.MessageID, err)
+		return result
+	}
+
+	// the providers missing the article at the time of the export, or all providers if they are configured under other names on this host
+	var targets []*Provider
+	for n := range providerList {
+		for _, name := range entry.MissingOn {
+			if providerList[n].Name == name {
+				targets = append(targets, &providerList[n])
+			}
+		}
+	}
+	if len(targets) == 0 {
+		for n := range providerList {
+			targets = append(targets, &providerList[n])
+		}
+	}
+	// the article may have been refreshed since the export
+	var missingOn []*Provider
+	for _, provider := range targets {
+		if found, err := checkMessageID(provider, entry.MessageID); err == nil && found {
+			result.Available = append(result.Available, provider.Name)
+		} else {
+			missingOn = append(missingOn, provider)
+		}
+	}
+	if len(missingOn) == 0 {
+		log.Printf("article <%s> is already available on all providers", entry.MessageID)
+		return result
+	}
+
+	var errs []string
+	for _, targets := range uploadTargets(missingOn) {
+		if provider, err := reuploadArticle(targets, article, entry.MessageID); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			result.PostedTo = append(result.PostedTo, provider.Name)
+		}
+	}
+	if len(errs) > 0 {
+		result.Error = strings.Join(errs, "; ")
+	}
+	return result
+}
+
+// readSpoolIndex reads the index of the spool directory, the latest entry of each message ID counts
+func readSpoolIndex(dir string) ([]spoolEntry, error) {
+	file, err := os.Open(filepath.Join(dir, spoolIndexName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the spool index: %v", err)
+	}
+	defer file.Close()
+	var entries []spoolEntry
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry spoolEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("unable to read line %v of the spool index: %v", line, err)
+		}
+		if n, ok := index[entry.MessageID]; ok {
+			entries[n] = entry
+		} else {
+			index[entry.MessageID] = len(entries)
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read the spool index: %v", err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].File != entries[j].File {
+			return entries[i].File < entries[j].File
+		}
+		return entries[i].Number < entries[j].Number
+	})
+	return entries, nil
+}
+
+// readSpoolResults returns the message IDs already uploaded without error
+func readSpoolResults(dir string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	file, err := os.Open(filepath.Join(dir, spoolResultsName))
+	if os.IsNotExist(err) {
+		return done, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read the upload results: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var result spoolResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			continue
+		}
+		done[result.MessageID] = result.Error == ""
+	}
+	return done, scanner.Err()
+}
+
+func writeSpoolResult(dir string, result spoolResult) error {
+	line, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	spoolResultsLock.Lock()
+	defer spoolResultsLock.Unlock()
+	f, err := os.OpenFile(filepath.Join(dir, spoolResultsName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write the upload result: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("unable to write the upload result: %v", err)
+	}
+	return nil
+}
+
+// readSpoolArticle reads a raw article written by the export
+func readSpoolArticle(path string) (*nntp.Article, error) {
+	message, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRawArticle(message)
+}
+
+// parseRawArticle parses an article written by rawArticle.
+// The body is converted to LF line endings, as the nntp library adds the CR when posting.
+func parseRawArticle(message []byte) (*nntp.Article, error) {
+	message = bytes.ReplaceAll(message, []byte("\r\n"), []byte("\n"))
+	head, body, ok := bytes.Cut(message, []byte("\n\n"))
+	if !ok {
+		return nil, fmt.Errorf("no empty line between the headers and the body")
+	}
+	article := &nntp.Article{Header: make(map[string][]string)}
+	var name string
+	for _, line := range strings.Split(string(head), "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && name != "" {
+			// folded header line
+			values := article.Header[name]
+			values[len(values)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header line '%s'", line)
+		}
+		name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(key))
+		article.Header[name] = append(article.Header[name], strings.TrimSpace(value))
+	}
+	if firstValue(article.Header["Message-Id"]) == "" {
+		return nil, fmt.Errorf("no Message-Id header")
+	}
+	article.Body = bytes.NewReader(body)
+	return article, nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Tensai75/nntp"
+)
+
+type (
+	// provider topology as inferred from the Path and Xref headers
+	topology struct {
+		Time      time.Time          `json:"time"`
+		Sampled   int                `json:"sampled"`
+		Providers []topologyProvider `json:"providers"`
+	}
+
+	topologyProvider struct {
+		Name        string   `json:"name"`
+		PathHost    string   `json:"pathHost,omitempty"`    // first entry of the Path header of the articles of the provider
+		SharedSpool []string `json:"sharedSpool,omitempty"` // providers with identical Xref article numbers
+		Feeds       []string `json:"feeds,omitempty"`       // providers whose articles passed through this provider
+		Reach       int      `json:"reach"`                 // number of other providers an upload to this provider propagates to
+	}
+
+	// headers of a sampled article relevant for the topology
+	topologyHead struct {
+		path []string
+		xref []string // sorted group:number pairs of the Xref header without the host
+	}
+)
+
+// runProvidersTopology fetches the headers of a sample of the articles of the NZB file
+// from each provider and infers which providers share a spool and which are peering
+func runProvidersTopology() {
+	setupLogging("providers", providersArgs.Debug)
+
+	if providerList, err = loadProviderList(providersArgs.Provider); err != nil {
+		exit(fmt.Errorf("unable to load provider list: %v", err))
+	}
+	nzb, err := loadNzbFile(providersArgs.NZBFile)
+	if err != nil {
+		exit(fmt.Errorf("unable to load NZB file '%s': %v", providersArgs.NZBFile, err))
+	}
+
+	// sample of message IDs spread evenly over the NZB file
+	var messageIDs []string
+	for _, file := range nzb.Files {
+		for _, segment := range file.Segments {
+			messageIDs = append(messageIDs, segment.Id)
+		}
+	}
+	if len(messageIDs) > providersArgs.Sample {
+		sample := make([]string, 0, providersArgs.Sample)
+		for i := 0; i < providersArgs.Sample; i++ {
+			sample = append(sample, messageIDs[i*len(messageIDs)/providersArgs.Sample])
+		}
+		messageIDs = sample
+	}
+
+	fmt.Printf("Fetching the headers of %v articles from %v providers\n", len(messageIDs), len(providerList))
+	heads := make([]map[string]topologyHead, len(providerList))
+	for n := range providerList {
+		if heads[n], err = fetchTopologyHeads(&providerList[n], messageIDs); err != nil {
+			fmt.Printf("Unable to fetch the headers from provider '%s': %v\n", providerList[n].Name, err)
+			log.Print(fmt.Errorf("unable to fetch the headers from provider '%s': %v", providerList[n].Name, err))
+		}
+	}
+
+	result := inferTopology(heads)
+	result.Sampled = len(messageIDs)
+	fmt.Println()
+	for _, provider := range result.Providers {
+		line := fmt.Sprintf("'%s' (path host: %s): reach: %v providers", provider.Name, valueOr(provider.PathHost, "unknown"), provider.Reach)
+		if len(provider.SharedSpool) > 0 {
+			line += fmt.Sprintf(" | shares the spool with: %s", strings.Join(provider.SharedSpool, ", "))
+		}
+		if len(provider.Feeds) > 0 {
+			line += fmt.Sprintf(" | feeds: %s", strings.Join(provider.Feeds, ", "))
+		}
+		fmt.Println(line)
+		log.Print(line)
+	}
+
+	if file, err := json.MarshalIndent(result, "", "  "); err != nil {
+		exit(fmt.Errorf("unable to encode the topology: %v", err))
+	} else if err := os.WriteFile(providersArgs.Topology, file, 0644); err != nil {
+		exit(fmt.Errorf("unable to write the topology file '%s': %v", providersArgs.Topology, err))
+	}
+	fmt.Printf("\nTopology written to '%s'\n", providersArgs.Topology)
+}
+
+// fetchTopologyHeads fetches the Path and Xref headers of the articles available on the provider
+func fetchTopologyHeads(provider *Provider, messageIDs []string) (map[string]topologyHead, error) {
+	conn, err := dialProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Quit()
+	if err := conn.Authenticate(provider.Username, provider.Password); err != nil {
+		return nil, err
+	}
+	heads := make(map[string]topologyHead)
+	for _, messageID := range messageIDs {
+		var article *nntp.Article
+		if article, err = conn.Head("<" + messageID + ">"); err != nil {
+			log.Print(fmt.Errorf("unable to load the header of article <%s> from provider '%s': %v", messageID, provider.Name, err))
+			continue
+		}
+		head := topologyHead{}
+		if path, ok := article.Header["Path"]; ok && len(path) > 0 {
+			for _, host := range strings.Split(path[0], "!") {
+				if host = strings.TrimSpace(host); host != "" {
+					head.path = append(head.path, host)
+				}
+			}
+		}
+		if xref, ok := article.Header["Xref"]; ok && len(xref) > 0 {
+			head.xref = parseXref(xref[0])
+		}
+		heads[messageID] = head
+	}
+	return heads, nil
+}
+
+// parseXref returns the sorted group:number pairs of an Xref header, without the leading host
+// which differs between the servers of the same spool
+func parseXref(xref string) []string {
+	var pairs []string
+	for _, field := range strings.Fields(xref) {
+		if group, number, ok := strings.Cut(field, ":"); ok && group != "" && number != "" {
+			pairs = append(pairs, field)
+		}
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// inferTopology infers the topology from the sampled headers of each provider (in the order of the provider list)
+func inferTopology(heads []map[string]topologyHead) topology {
+	result := topology{Time: time.Now()}
+
+	// the path host of a provider is the most common first entry of the Path headers
+	pathHosts := make([]string, len(providerList))
+	for n := range providerList {
+		counts := make(map[string]int)
+		for _, head := range heads[n] {
+			if len(head.path) > 0 {
+				counts[head.path[0]]++
+			}
+		}
+		for _, host := range sortedKeys(counts) {
+			if counts[host] > counts[pathHosts[n]] {
+				pathHosts[n] = host
+			}
+		}
+	}
+
+	// edges[x][y] is true if articles of provider x reach provider y
+	edges := make([][]bool, len(providerList))
+	for x := range providerList {
+		edges[x] = make([]bool, len(providerList))
+	}
+	shared := make([][]bool, len(providerList))
+	for x := range providerList {
+		shared[x] = make([]bool, len(providerList))
+		for y := range providerList {
+			if x == y {
+				continue
+			}
+			// providers share a spool if the Xref article numbers of all the articles available on both are identical
+			compared, identical := 0, 0
+			for messageID, head := range heads[x] {
+				if other, ok := heads[y][messageID]; ok && len(head.xref) > 0 && len(other.xref) > 0 {
+					compared++
+					if slices.Equal(head.xref, other.xref) {
+						identical++
+					}
+				}
+			}
+			if compared > 0 && compared == identical {
+				shared[x][y] = true
+				edges[x][y] = true
+			}
+			// x feeds y if the path host of x appears in the Path headers of the articles of y
+			if pathHosts[x] != "" && pathHosts[x] != pathHosts[y] {
+				for _, head := range heads[y] {
+					if len(head.path) > 1 && slices.Contains(head.path[1:], pathHosts[x]) {
+						edges[x][y] = true
+						break
+					}
+				}
+			}
+		}
+	}
+
+	for x := range providerList {
+		provider := topologyProvider{
+			Name:     providerList[x].Name,
+			PathHost: pathHosts[x],
+			Reach:    reach(edges, x),
+		}
+		for y := range providerList {
+			if shared[x][y] {
+				provider.SharedSpool = append(provider.SharedSpool, providerList[y].Name)
+			} else if edges[x][y] {
+				provider.Feeds = append(provider.Feeds, providerList[y].Name)
+			}
+		}
+		result.Providers = append(result.Providers, provider)
+	}
+	return result
+}
+
+// reach returns the number of other providers reachable from provider x
+func reach(edges [][]bool, x int) int {
+	reached := make([]bool, len(edges))
+	reached[x] = true
+	queue := []int{x}
+	count := 0
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for y, edge := range edges[current] {
+			if edge && !reached[y] {
+				reached[y] = true
+				count++
+				queue = append(queue, y)
+			}
+		}
+	}
+	return count
+}
+
+// loadTopology sets the reach of the providers from the topology file, if it exists
+func loadTopology(path string) {
+	if path == "" {
+		return
+	}
+	file, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print(fmt.Errorf("unable to read the topology file '%s': %v", path, err))
+		}
+		return
+	}
+	var result topology
+	if err := json.Unmarshal(file, &result); err != nil {
+		log.Print(fmt.Errorf("unable to parse the topology file '%s': %v", path, err))
+		return
+	}
+	for _, topologyProvider := range result.Providers {
+		for n := range providerList {
+			if providerList[n].Name == topologyProvider.Name {
+				providerList[n].reach = topologyProvider.Reach
+			}
+		}
+	}
+	log.Printf("loaded the provider topology of %s from '%s'", result.Time.Format(time.RFC3339), path)
+}
+
+// sortByReach returns the providers sorted by the number of providers an upload propagates to
+func sortByReach(providers []*Provider) []*Provider {
+	sorted := slices.Clone(providers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].reach > sorted[j].reach
+	})
+	return sorted
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/Tensai75/nntp"
+	"github.com/Tensai75/nzbparser"
+)
+
+var errInvalidArticle = fmt.Errorf("not re-uploaded as it doesn't match the NZB file")
+
+// part counter of a subject, e.g. "yEnc (3/5)", which may be followed by other text such as the file size
+var subjectCounterRegexp = regexp.MustCompile(`\(\d+/\d+\)`)
+
+// the segment size in the NZB file includes the headers and the yEnc overhead of the article,
+// so the decoded data must be smaller, but not by more than this share plus the headers
+const (
+	maxYencOverhead = 0.1
+	maxHeaderSize   = 4096
+)
+
+// validateArticle compares the article loaded for the re-upload with the segment of the NZB file,
+// so an article with a colliding message ID or with wrong content is never spread to the other providers
+func validateArticle(article *nntp.Article, segment nzbparser.NzbSegment, fileSubject string) error {
+	if args.NoValidation {
+		return nil
+	}
+	body, err := io.ReadAll(article.Body)
+	if err != nil {
+		return err
+	}
+	article.Body = bytes.NewReader(body)
+
+	yenc, err := decodeYenc(body)
+	if err != nil {
+		return err
+	}
+	size := int64(len(yenc.data))
+	if yenc.part > 0 && yenc.part != segment.Number {
+		return fmt.Errorf("yEnc part %v doesn't match segment number %v", yenc.part, segment.Number)
+	}
+	if yenc.begin > 0 {
+		if partSize := yenc.end - yenc.begin + 1; partSize != size {
+			return fmt.Errorf("decoded size %v doesn't match the yEnc part from %v to %v", size, yenc.begin, yenc.end)
+		} else if yenc.part > 0 && yenc.part < yenc.total && yenc.begin-1 != int64(yenc.part-1)*partSize {
+			return fmt.Errorf("yEnc part begins at offset %v instead of %v", yenc.begin, int64(yenc.part-1)*partSize+1)
+		} else if yenc.part > 0 && yenc.part == yenc.total && yenc.fileSize > 0 && yenc.end != yenc.fileSize {
+			return fmt.Errorf("last yEnc part ends at offset %v instead of the file size %v", yenc.end, yenc.fileSize)
+		}
+	}
+	if yenc.partSize > 0 && yenc.partSize != size {
+		return fmt.Errorf("decoded size %v doesn't match the yEnc size %v", size, yenc.partSize)
+	}
+	if err := yenc.checkCRC(); err != nil {
+		return err
+	}
+	if segment.Bytes > 0 {
+		if size > int64(segment.Bytes) {
+			return fmt.Errorf("decoded size %v is larger than the segment size %v", size, segment.Bytes)
+		} else if minSize := int64(float64(segment.Bytes)*(1-maxYencOverhead)) - maxHeaderSize; size < minSize {
+			return fmt.Errorf("decoded size %v is too small for the segment size %v", size, segment.Bytes)
+		}
+	}
+	if subject := firstValue(article.Header["Subject"]); subject != "" && fileSubject != "" &&
+		normalizeSubject(subject) != normalizeSubject(fileSubject) {
+		return fmt.Errorf("subject '%s' doesn't match the subject '%s' of the NZB file", strings.TrimSpace(subject), fileSubject)
+	}
+	return nil
+}
+
+// normalizeSubject removes the numbers of the counters of the subject, wherever they appear
+func normalizeSubject(subject string) string {
+	return strings.TrimSpace(subjectCounterRegexp.ReplaceAllString(subject, "()"))
+}
+
+// renumberSubject sets the part counter of the subject, which is the last counter of the subject
+func renumberSubject(subject string, part int, total int) string {
+	matches := subjectCounterRegexp.FindAllStringIndex(subject, -1)
+	if len(matches) == 0 {
+		return subject
+	}
+	last := matches[len(matches)-1]
+	return subject[:last[0]] + fmt.Sprintf("(%d/%d)", part, total) + subject[last[1]:]
+}
@@ -0,0 +1,190 @@
+"use strict";
+
+// the API token is asked for once and kept in the local storage
+let token = localStorage.getItem("nzbrefreshToken") || "";
+let selectedJob = null;
+
+async function api(path, options = {}) {
+  options.headers = options.headers || {};
+  if (token) {
+    options.headers["Authorization"] = "Bearer " + token;
+  }
+  const response = await fetch(path, options);
+  if (response.status === 401) {
+    token = prompt("API token") || "";
+    localStorage.setItem("nzbrefreshToken", token);
+    return api(path, options);
+  }
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || response.statusText);
+  }
+  return data;
+}
+
+function el(tag, text, className) {
+  const element = document.createElement(tag);
+  if (text !== undefined) {
+    element.textContent = text;
+  }
+  if (className) {
+    element.className = className;
+  }
+  return element;
+}
+
+function formatDate(value) {
+  return value ? new Date(value).toLocaleString() : "";
+}
+
+function percent(value, total) {
+  return total > 0 ? (value / total * 100).toFixed(2) + "%" : "-";
+}
+
+function progressCell(done, total) {
+  const cell = el("td");
+  if (total > 0) {
+    const bar = el("progress");
+    bar.max = total;
+    bar.value = done;
+    cell.append(bar, " " + done + "/" + total);
+  }
+  return cell;
+}
+
+async function refreshJobs() {
+  const jobs = await api("/api/jobs");
+  const body = document.querySelector("#jobs tbody");
+  body.replaceChildren();
+  for (const job of jobs.reverse()) {
+    const row = el("tr", undefined, "job" + (job.id === selectedJob ? " selected" : ""));
+    row.append(
+      el("td", job.name),
+      el("td", job.checkOnly ? "check" : "refresh"),
+      el("td", job.status + (job.error ? ": " + job.error : ""), "status-" + job.status),
+      el("td", formatDate(job.created)),
+      job.progress ? progressCell(job.progress.segmentsChecked, job.progress.segments) : el("td"),
+      job.progress ? progressCell(job.progress.uploadsDone, job.progress.uploads) : el("td"),
+    );
+    const actions = el("td");
+    if (job.status === "queued" || job.status === "running") {
+      const cancel = el("button", "Cancel");
+      cancel.onclick = async (event) => {
+        event.stopPropagation();
+        await api("/api/jobs/" + job.id, { method: "DELETE" });
+        refresh();
+      };
+      actions.append(cancel);
+    }
+    row.append(actions);
+    row.onclick = () => {
+      selectedJob = job.id;
+      refresh();
+    };
+    body.append(row);
+    if (job.id === selectedJob) {
+      showJob(job);
+    }
+  }
+}
+
+async function showJob(job) {
+  document.getElementById("details").hidden = false;
+  document.getElementById("details-name").textContent = job.name + " (" + job.status + ")";
+
+  const providers = document.querySelector("#providers tbody");
+  providers.replaceChildren();
+  for (const provider of job.providers || []) {
+    const row = el("tr");
+    row.append(
+      el("td", provider.name + (provider.offline ? " (offline)" : "")),
+      el("td", provider.checked),
+      el("td", provider.available + " (" + percent(provider.available, provider.checked) + ")"),
+      el("td", provider.missing),
+      el("td", provider.refreshed),
+      el("td", provider.connections || ""),
+    );
+    providers.append(row);
+  }
+
+  // heatmap of the available segments per file and provider
+  const files = await api("/api/jobs/" + job.id + "/files");
+  const names = (job.providers || []).map((provider) => provider.name);
+  const heatmap = document.getElementById("heatmap");
+  heatmap.replaceChildren();
+  const head = el("tr");
+  head.append(el("th", "File"), el("th", "Segments"));
+  for (const name of names) {
+    head.append(el("th", name));
+  }
+  heatmap.append(head);
+  for (const file of files) {
+    const row = el("tr");
+    row.append(el("td", file.name), el("td", file.totalSegments));
+    for (const name of names) {
+      const available = (file.available && file.available[name]) || 0;
+      const share = file.totalSegments > 0 ? available / file.totalSegments : 0;
+      const cell = el("td", percent(available, file.totalSegments), "cell");
+      cell.style.background = "hsl(" + Math.round(share * 120) + ", 70%, 70%)";
+      cell.title = available + " of " + file.totalSegments + " segments available";
+      row.append(cell);
+    }
+    heatmap.append(row);
+  }
+}
+
+async function refreshHistory() {
+  const records = await api("/api/history");
+  const body = document.querySelector("#history tbody");
+  body.replaceChildren();
+  for (const record of records.reverse().slice(0, 100)) {
+    const row = el("tr");
+    row.append(
+      el("td", formatDate(record.time)),
+      el("td", record.nzbName),
+      el("td", record.checkOnly ? "check" : "refresh"),
+      el("td", record.totalSegments),
+      el("td", (record.providers || []).map((provider) =>
+        provider.name + ": " + percent(provider.available, provider.checked)).join(" | ")),
+    );
+    body.append(row);
+  }
+}
+
+async function refresh() {
+  try {
+    await refreshJobs();
+  } catch (error) {
+    console.error(error);
+  }
+}
+
+document.getElementById("submit").onsubmit = async (event) => {
+  event.preventDefault();
+  const file = document.getElementById("nzb").files[0];
+  const path = document.getElementById("path").value;
+  const checkOnly = document.getElementById("checkOnly").checked;
+  try {
+    if (file) {
+      const form = new FormData();
+      form.append("nzb", file);
+      form.append("checkOnly", checkOnly ? "true" : "false");
+      await api("/api/jobs", { method: "POST", body: form });
+    } else {
+      await api("/api/jobs", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ path: path, checkOnly: checkOnly }),
+      });
+    }
+    event.target.reset();
+    refresh();
+  } catch (error) {
+    alert(error.message);
+  }
+};
+
+refresh();
+refreshHistory().catch(console.error);
+setInterval(refresh, 1000);
+setInterval(() => refreshHistory().catch(console.error), 30000);
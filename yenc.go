@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+// decoded yEnc part of an article
+type yencPart struct {
+	name     string
+	part     int
+	total    int
+	line     int   // line length (=ybegin line)
+	fileSize int64 // size of the whole file (=ybegin size)
+	begin    int64 // offset of the part in the file, starting with 1 (=ypart begin)
+	end      int64
+	partSize int64 // size of the part according to =yend size
+	crc      uint32
+	hasCRC   bool
+	data     []byte
+}
+
+// decodeYenc decodes the yEnc encoded body of an article
+func decodeYenc(body []byte) (*yencPart, error) {
+	result := &yencPart{}
+	var data bytes.Buffer
+	inData, ended := false, false
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		switch {
+		case bytes.HasPrefix(line, []byte("=ybegin ")):
+			values := yencValues(string(line[len("=ybegin "):]))
+			result.name = values["name"]
+			result.part, _ = strconv.Atoi(values["part"])
+			result.total, _ = strconv.Atoi(values["total"])
+			result.line, _ = strconv.Atoi(values["line"])
+			result.fileSize, _ = strconv.ParseInt(values["size"], 10, 64)
+			inData = true
+		case bytes.HasPrefix(line, []byte("=ypart ")):
+			values := yencValues(string(line[len("=ypart "):]))
+			result.begin, _ = strconv.ParseInt(values["begin"], 10, 64)
+			result.end, _ = strconv.ParseInt(values["end"], 10, 64)
+		case bytes.HasPrefix(line, []byte("=yend")):
+			values := yencValues(string(line[len("=yend"):]))
+			result.partSize, _ = strconv.ParseInt(values["size"], 10, 64)
+			if crc, ok := values["pcrc32"]; ok {
+				if value, err := strconv.ParseUint(crc, 16, 32); err == nil {
+					result.crc, result.hasCRC = uint32(value), true
+				}
+			} else if crc, ok := values["crc32"]; ok && result.part == 0 {
+				// single part articles only have the crc32 of the whole file
+				if value, err := strconv.ParseUint(crc, 16, 32); err == nil {
+					result.crc, result.hasCRC = uint32(value), true
+				}
+			}
+			ended = true
+		case inData && !ended:
+			escaped := false
+			for _, c := range bytes.TrimRight(line, "\r") {
+				if escaped {
+					data.WriteByte(c - 64 - 42)
+					escaped = false
+				} else if c == '=' {
+					escaped = true
+				} else {
+					data.WriteByte(c - 42)
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !inData {
+		return nil, fmt.Errorf("no yEnc data found")
+	}
+	if !ended {
+		return nil, fmt.Errorf("yEnc data is truncated (no =yend line)")
+	}
+	result.data = data.Bytes()
+	return result, nil
+}
+
+// yencValues parses the keyword=value pairs of a yEnc header line, the name is the rest of the line
+func yencValues(line string) map[string]string {
+	values := make(map[string]string)
+	if before, name, ok := strings.Cut(line, "name="); ok {
+		values["name"] = strings.TrimSpace(name)
+		line = before
+	}
+	for _, field := range strings.Fields(line) {
+		if key, value, ok := strings.Cut(field, "="); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// checkCRC returns an error if the decoded data doesn't match the CRC32 of the part
+func (y *yencPart) checkCRC() error {
+	if y.hasCRC {
+		if crc := crc32.ChecksumIEEE(y.data); crc != y.crc {
+			return fmt.Errorf("CRC32 of the decoded data is %08x instead of %08x", crc, y.crc)
+		}
+	}
+	return nil
+}
+
+// encodeYenc encodes a part of a file with yEnc, the lines of the body end with LF
+func encodeYenc(name string, part, total int, fileSize, begin int64, data []byte, lineLength int) []byte {
+	var body bytes.Buffer
+	if total > 1 {
+		fmt.Fprintf(&body, "=ybegin part=%d total=%d line=%d size=%d name=%s\n", part, total, lineLength, fileSize, name)
+		fmt.Fprintf(&body, "=ypart begin=%d end=%d\n", begin, begin+int64(len(data))-1)
+	} else {
+		fmt.Fprintf(&body, "=ybegin line=%d size=%d name=%s\n", lineLength, fileSize, name)
+	}
+	column := 0
+	for n, c := range data {
+		e := c + 42
+		escape := false
+		switch e {
+		case 0x00, '\n', '\r', '=':
+			escape = true
+		case '\t', ' ':
+			// whitespace at the beginning or the end of a line might get lost
+			escape = column == 0 || column >= lineLength-1 || n == len(data)-1
+		case '.':
+			escape = column == 0
+		}
+		if escape {
+			body.WriteByte('=')
+			body.WriteByte(e + 64)
+			column += 2
+		} else {
+			body.WriteByte(e)
+			column++
+		}
+		if column >= lineLength && n < len(data)-1 {
+			body.WriteByte('\n')
+			column = 0
+		}
+	}
+	body.WriteByte('\n')
+	if total > 1 {
+		fmt.Fprintf(&body, "=yend size=%d part=%d pcrc32=%08x\n", len(data), part, crc32.ChecksumIEEE(data))
+	} else {
+		fmt.Fprintf(&body, "=yend size=%d crc32=%08x\n", len(data), crc32.ChecksumIEEE(data))
+	}
+	return body.Bytes()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestYencRoundTrip(t *testing.T) {
+	// all byte values, including the ones encoded as critical characters, and whitespace at the line ends
+	data := make([]byte, 0, 3000)
+	for n := 0; n < 256; n++ {
+		data = append(data, byte(n))
+	}
+	data = append(data, bytes.Repeat([]byte{'\t' + 256 - 42, ' ' + 256 - 42, '.' - 42}, 100)...)
+	random := make([]byte, 3000-len(data))
+	rand.New(rand.NewSource(4)).Read(random)
+	data = append(data, random...)
+
+	for _, test := range []struct {
+		name     string
+		part     int
+		total    int
+		fileSize int64
+		begin    int64
+		size     int
+		line     int
+	}{
+		{name: "single part", part: 1, total: 1, fileSize: 3000, begin: 1, size: 3000, line: 128},
+		{name: "first part", part: 1, total: 3, fileSize: 3000, begin: 1, size: 1000, line: 128},
+		{name: "last part", part: 3, total: 3, fileSize: 3000, begin: 2001, size: 1000, line: 64},
+		{name: "short lines", part: 2, total: 3, fileSize: 3000, begin: 1001, size: 1000, line: 3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			part := data[test.begin-1 : test.begin-1+int64(test.size)]
+			body := encodeYenc("file.bin", test.part, test.total, test.fileSize, test.begin, part, test.line)
+			for _, line := range bytes.Split(body, []byte("\n")) {
+				if bytes.HasPrefix(line, []byte(".")) {
+					t.Fatalf("line starting with a dot: %q", line)
+				}
+				if !bytes.HasPrefix(line, []byte("=y")) && len(line) > test.line+1 {
+					t.Fatalf("line of %v characters exceeds the line length %v", len(line), test.line)
+				}
+			}
+
+			yenc, err := decodeYenc(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(yenc.data, part) {
+				t.Fatalf("decoded data doesn't match the encoded data")
+			}
+			if err := yenc.checkCRC(); err != nil {
+				t.Fatal(err)
+			}
+			if yenc.name != "file.bin" || yenc.line != test.line || yenc.fileSize != test.fileSize || yenc.partSize != int64(test.size) {
+				t.Fatalf("decoded headers name=%v line=%v size=%v part size=%v don't match", yenc.name, yenc.line, yenc.fileSize, yenc.partSize)
+			}
+			if test.total > 1 {
+				if yenc.part != test.part || yenc.total != test.total || yenc.begin != test.begin || yenc.end != test.begin+int64(test.size)-1 {
+					t.Fatalf("decoded part %v/%v from %v to %v doesn't match", yenc.part, yenc.total, yenc.begin, yenc.end)
+				}
+			}
+		})
+	}
+}